@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/bacerrors"
+	"github.com/bacalhau-project/bacalhau/pkg/lib/concurrency"
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+	client "github.com/bacalhau-project/bacalhau/pkg/publicapi/client/v2"
+)
+
+// Subset of the Bacalhau jobs API used by this program, so it can be swapped for a fake
+type jobsAPI interface {
+	Put(ctx context.Context, r *apimodels.PutJobRequest) (*apimodels.PutJobResponse, error)
+	Get(ctx context.Context, r *apimodels.GetJobRequest) (*apimodels.GetJobResponse, error)
+	List(ctx context.Context, r *apimodels.ListJobsRequest) (*apimodels.ListJobsResponse, error)
+	Results(ctx context.Context, r *apimodels.ListJobResultsRequest) (*apimodels.ListJobResultsResponse, error)
+	Stop(ctx context.Context, r *apimodels.StopJobRequest) (*apimodels.StopJobResponse, error)
+	Logs(ctx context.Context, r *apimodels.GetLogsRequest) (<-chan *concurrency.AsyncResult[models.ExecutionLog], error)
+}
+
+var _ jobsAPI = (*client.Jobs)(nil)
+
+// Subset of the Bacalhau agent API used to check the orchestrator is up
+type agentAPI interface {
+	Version(ctx context.Context) (*apimodels.GetVersionResponse, error)
+}
+
+var _ agentAPI = (*client.Agent)(nil)
+
+// How long the preflight check waits for the orchestrator to answer
+const preflightTimeout = 5 * time.Second
+
+// Ask the orchestrator for its version before submitting, so an unreachable
+// API fails with a clear message rather than an error from submission
+func checkOrchestrator(ctx context.Context, agent agentAPI, apiHost string) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	resp, err := agent.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot reach Bacalhau API at %s: %s", apiHost, err.Error())
+	}
+	if resp.BuildVersionInfo != nil {
+		slog.Debug("Bacalhau API is reachable", "host", apiHost, "version", resp.GitVersion)
+	}
+
+	return nil
+}
+
+// Report whether an API call failed in a way worth retrying: timeouts, an
+// unreachable orchestrator or a server error, but not a rejected request
+func isRetryableAPIError(err error) bool {
+	var apiErr bacerrors.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Retryable() || apiErr.Code() == bacerrors.ServiceUnavailable {
+		return true
+	}
+
+	status := apiErr.HTTPStatusCode()
+	return status >= http.StatusInternalServerError && status != http.StatusNotImplemented
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/lib/concurrency"
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// In-memory jobsAPI. Each status check moves a job one step along its
+// scripted states, after which it stays in the last one.
+type fakeJobs struct {
+	mu sync.Mutex
+
+	// State sequences of known jobs by ID, and of jobs yet to be submitted, in
+	// the order they will be
+	states    map[string][]models.JobStateType
+	submitted [][]models.JobStateType
+	// Returned by Put alongside each job ID
+	warnings []string
+	// Returned by status checks, in turn, before any job state is. A nil
+	// entry lets that check through.
+	getErrs []error
+	// Returned by Results for every job
+	results    []*models.SpecConfig
+	resultsErr error
+
+	// Output the task printed before logs were requested, sent unless a
+	// request asks for new output only
+	history []string
+
+	puts        []*models.Job
+	gets        map[string]int
+	stops       []string
+	logRequests []*apimodels.GetLogsRequest
+}
+
+var _ jobsAPI = (*fakeJobs)(nil)
+
+// Fake knowing a single job, job-1, that goes through states. Without states
+// it knows no jobs until some are submitted.
+func newFakeJobs(states ...models.JobStateType) *fakeJobs {
+	f := &fakeJobs{
+		states: map[string][]models.JobStateType{},
+		gets:   map[string]int{},
+	}
+	if len(states) > 0 {
+		f.states["job-1"] = states
+	}
+
+	return f
+}
+
+func (f *fakeJobs) Put(ctx context.Context, r *apimodels.PutJobRequest) (*apimodels.PutJobResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.puts = append(f.puts, r.Job)
+	jobID := fmt.Sprintf("job-%d", len(f.states)+1)
+	if len(f.submitted) > 0 {
+		f.states[jobID] = f.submitted[0]
+		f.submitted = f.submitted[1:]
+	}
+
+	return &apimodels.PutJobResponse{JobID: jobID, Warnings: f.warnings}, nil
+}
+
+func (f *fakeJobs) Get(ctx context.Context, r *apimodels.GetJobRequest) (*apimodels.GetJobResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.getErrs) > 0 {
+		err := f.getErrs[0]
+		f.getErrs = f.getErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	states, ok := f.states[r.JobID]
+	if !ok || len(states) == 0 {
+		return nil, fmt.Errorf("job %s not found", r.JobID)
+	}
+	step := min(f.gets[r.JobID], len(states)-1)
+	f.gets[r.JobID]++
+
+	state := models.NewJobState(states[step])
+	if state.StateType == models.JobStateTypeFailed {
+		state.Message = "task exited with code 1"
+	}
+	return &apimodels.GetJobResponse{
+		Job: &models.Job{
+			ID:         r.JobID,
+			Name:       "fake",
+			Type:       models.JobTypeBatch,
+			State:      state,
+			CreateTime: time.Now().UnixNano(),
+		},
+		Executions: &apimodels.ListJobExecutionsResponse{},
+	}, nil
+}
+
+func (f *fakeJobs) List(ctx context.Context, r *apimodels.ListJobsRequest) (*apimodels.ListJobsResponse, error) {
+	return &apimodels.ListJobsResponse{}, nil
+}
+
+func (f *fakeJobs) Results(ctx context.Context, r *apimodels.ListJobResultsRequest) (*apimodels.ListJobResultsResponse, error) {
+	if f.resultsErr != nil {
+		return nil, f.resultsErr
+	}
+
+	return &apimodels.ListJobResultsResponse{Items: f.results}, nil
+}
+
+func (f *fakeJobs) Stop(ctx context.Context, r *apimodels.StopJobRequest) (*apimodels.StopJobResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stops = append(f.stops, r.JobID)
+	return &apimodels.StopJobResponse{}, nil
+}
+
+func (f *fakeJobs) Logs(ctx context.Context, r *apimodels.GetLogsRequest) (<-chan *concurrency.AsyncResult[models.ExecutionLog], error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.logRequests = append(f.logRequests, r)
+	logs := make(chan *concurrency.AsyncResult[models.ExecutionLog], len(f.history))
+	if !r.Tail {
+		for _, line := range f.history {
+			logs <- &concurrency.AsyncResult[models.ExecutionLog]{Value: models.ExecutionLog{Line: line}}
+		}
+	}
+	close(logs)
+
+	return logs, nil
+}
+
+// Number of status checks made for a job
+func (f *fakeJobs) getCount(jobID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.gets[jobID]
+}
+
+// Options as parsed from args, writing outputs to a temporary directory and
+// polling quickly enough for tests
+func testOptions(t *testing.T, args ...string) *options {
+	t.Helper()
+
+	opts, err := parseFlags(append([]string{"-output-dir", t.TempDir()}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.pollMin = time.Millisecond
+	opts.pollMax = 5 * time.Millisecond
+
+	return opts
+}
+
+// Serve archive as the local publisher would, returning the result pointing at it
+func serveResult(t *testing.T, name string, archive []byte) *models.SpecConfig {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+name {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Write(archive)
+	}))
+	t.Cleanup(server.Close)
+
+	return &models.SpecConfig{Type: "local", Params: map[string]any{"URL": server.URL + "/" + name}}
+}
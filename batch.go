@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Container path for -batch-file inputs that don't specify one
+const defaultBatchTarget = "/inputs"
+
+// Read a -batch-file, one input per line as /host/path[:/container/path[:rw]].
+// Blank lines and lines starting with # are skipped.
+func parseBatchFile(path string) ([]localInput, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening batch file: %s", err.Error())
+	}
+	defer file.Close()
+
+	var inputs []localInput
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		spec := strings.TrimSpace(scanner.Text())
+		if spec == "" || strings.HasPrefix(spec, "#") {
+			continue
+		}
+		if !strings.Contains(spec, ":") {
+			spec += ":" + defaultBatchTarget
+		}
+
+		input, err := parseLocalInput(spec)
+		if err != nil {
+			return nil, fmt.Errorf("batch file %s line %d: %s", path, line, err.Error())
+		}
+		inputs = append(inputs, input)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading batch file: %s", err.Error())
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("batch file %s has no inputs", path)
+	}
+
+	return inputs, nil
+}
+
+// Outcome of one job of a batch
+type batchResult struct {
+	Input      string `json:"input"`
+	JobID      string `json:"jobID,omitempty"`
+	State      string `json:"state,omitempty"`
+	OutputPath string `json:"outputPath,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Summary of a batch, printed to stdout with -output json
+type batchSummary struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Jobs      []batchResult `json:"jobs"`
+}
+
+// Submit a job per -batch-file input, with at most -batch-concurrency running
+// at once, and retrieve each one's outputs into its own directory
+func runBatch(ctx context.Context, stopSignals context.CancelFunc, jobs jobsAPI, opts *options) int {
+	// Render every job first, so a bad template doesn't leave half a batch running
+	batch := make([]*options, 0, len(opts.batchInputs))
+	for i, input := range opts.batchInputs {
+		jobOpts := *opts
+		jobOpts.name = fmt.Sprintf("%s-%d", opts.name, i+1)
+		jobOpts.inputs = append([]localInput{input}, opts.inputs...)
+		jobOpts.entrypoint = slices.Clone(opts.entrypoint)
+		// Progress bars from concurrent downloads would garble each other
+		jobOpts.quiet = opts.quiet || opts.batchConcurrency > 1
+		if err := renderEntrypoint(&jobOpts); err != nil {
+			slog.Error("Failed to render entrypoint", "input", input.hostPath, "error", err)
+			return 1
+		}
+		batch = append(batch, &jobOpts)
+	}
+	// Every job shares the same publisher and result paths
+	job := getJob(batch[0])
+	if err := validateResults(&job); err != nil {
+		slog.Error("Invalid job", "error", err)
+		return 1
+	}
+	slog.Info("Submitting batch", "jobs", len(batch), "concurrency", opts.batchConcurrency)
+
+	results := make([]batchResult, len(batch))
+	slots := make(chan struct{}, opts.batchConcurrency)
+	var wg sync.WaitGroup
+	for i, jobOpts := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i] = batchResult{Input: opts.batchInputs[i].hostPath}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i].Error = ctx.Err().Error()
+				return
+			}
+			defer func() { <-slots }()
+
+			runBatchJob(ctx, stopSignals, jobs, jobOpts, &results[i])
+		}()
+	}
+	wg.Wait()
+
+	summary := batchSummary{Jobs: results}
+	for _, result := range results {
+		if result.Error != "" {
+			summary.Failed++
+			slog.Error("Batch job failed", "input", result.Input, "jobID", result.JobID, "error", result.Error)
+		} else {
+			summary.Succeeded++
+		}
+	}
+	slog.Info("Batch finished", "succeeded", summary.Succeeded, "failed", summary.Failed)
+
+	if opts.outputFormat == "json" {
+		if err := writeBatchSummary(os.Stdout, summary); err != nil {
+			slog.Error("Failed to write summary", "error", err)
+			return 1
+		}
+	}
+	if summary.Failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// Submit one job of a batch, wait for it and retrieve its outputs, recording
+// how it went in result
+func runBatchJob(ctx context.Context, stopSignals context.CancelFunc, jobs jobsAPI, opts *options, result *batchResult) {
+	job := getJob(opts)
+	jobID, timeline, err := submitAndRecord(ctx, jobs, &job, opts, "input", result.Input)
+	if err != nil {
+		result.Error = fmt.Sprintf("error submitting job: %s", err.Error())
+		return
+	}
+	result.JobID = jobID
+
+	jobID, jobInfo, err := waitForFinalState(ctx, stopSignals, jobs, job, jobID, opts, timeline)
+	if jobID != "" {
+		result.JobID = jobID
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("error getting job status: %s", err.Error())
+		return
+	}
+	defer logTimeline(jobID, timeline)
+
+	finalJob := jobInfo.Job
+	result.State = finalJob.State.StateType.String()
+	if err := reportFinalState(jobInfo); err != nil {
+		result.Error = err.Error()
+		return
+	}
+	if finalJob.State.StateType != models.JobStateTypeCompleted || opts.noResults {
+		return
+	}
+
+	retrieved, err := retrieveOutputs(ctx, jobs, finalJob, opts)
+	if err != nil {
+		result.Error = fmt.Sprintf("error retrieving results: %s", err.Error())
+		var partial partialOutputError
+		if errors.As(err, &partial) {
+			cleanupPartialOutputs(jobID, partial.path, opts.cleanupOnError)
+		}
+		return
+	}
+	result.OutputPath = retrieved.path
+	slog.Info("Results available", "jobID", jobID, "path", retrieved.path)
+}
+
+func writeBatchSummary(w io.Writer, summary batchSummary) error {
+	jsonData, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding summary: %s", err.Error())
+	}
+
+	_, err = fmt.Fprintln(w, string(jsonData))
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestRunBatchJob(t *testing.T) {
+	tests := []struct {
+		name      string
+		states    []models.JobStateType
+		wantState string
+		wantError string
+	}{
+		{
+			name:      "completed",
+			states:    []models.JobStateType{models.JobStateTypeRunning, models.JobStateTypeCompleted},
+			wantState: "Completed",
+		},
+		{
+			name:      "failed",
+			states:    []models.JobStateType{models.JobStateTypeRunning, models.JobStateTypeFailed},
+			wantState: "Failed",
+			wantError: "job failed: task exited with code 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newFakeJobs()
+			jobs.submitted = [][]models.JobStateType{tt.states}
+			jobs.results = []*models.SpecConfig{serveResult(t, "exec-1.tar.gz", gzipBytes(t, tarArchive(t,
+				tarEntry{name: "outputs/output.txt", body: "hello"},
+			)))}
+			opts := testOptions(t)
+
+			result := batchResult{Input: "input-1"}
+			runBatchJob(context.Background(), func() {}, jobs, opts, &result)
+
+			if result.JobID != "job-1" || result.State != tt.wantState || result.Error != tt.wantError {
+				t.Fatalf("got %+v, want job-1 in state %s with error %q", result, tt.wantState, tt.wantError)
+			}
+			if tt.wantError == "" && result.OutputPath != filepath.Join(opts.outputDir, "job-1") {
+				t.Fatalf("got output path %q, want the job's directory", result.OutputPath)
+			}
+		})
+	}
+}
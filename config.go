@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables that take precedence over a -config file and the
+// command line, keyed by the flag they stand in for
+var flagEnvVars = map[string]string{
+	"api-host":  "BACALHAU_API_HOST",
+	"api-token": "BACALHAU_API_TOKEN",
+}
+
+// Contents of a -config file: flag names mapped to the values they would be
+// given on the command line
+type configFile map[string]configValue
+
+// A flag's value in a config file. A list sets a repeatable flag once per item.
+type configValue []string
+
+func (v *configValue) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*v = configValue{node.Value}
+	case yaml.SequenceNode:
+		values := make(configValue, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: list items must be plain values", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		*v = values
+	default:
+		return fmt.Errorf("line %d: expected a value or a list of values", node.Line)
+	}
+
+	return nil
+}
+
+func loadConfigFile(path string) (configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err.Error())
+	}
+
+	var config configFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %s", path, err.Error())
+	}
+
+	return config, nil
+}
+
+// Apply the -config file and the environment to the parsed command line, in
+// order of precedence: defaults, then the file, then flags, then environment
+// variables. Call once the command line has been parsed.
+func applyConfig(fs *flag.FlagSet) error {
+	if err := applyConfigFile(fs); err != nil {
+		return err
+	}
+
+	for name, env := range flagEnvVars {
+		value := os.Getenv(env)
+		if value == "" || fs.Lookup(name) == nil {
+			continue
+		}
+		if isFlagSet(fs, name) && fs.Lookup(name).Value.String() != value {
+			slog.Warn("Environment variable overrides flag", "env", env, "flag", name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value of %s: %s", env, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Set each flag named in the -config file, if one was given, unless it was
+// passed on the command line. Keys for flags this command doesn't have are
+// skipped, so one file can serve every command, but keys no command has are an
+// error, so typos aren't lost.
+func applyConfigFile(fs *flag.FlagSet) error {
+	configFlag := fs.Lookup("config")
+	if configFlag == nil || configFlag.Value.String() == "" {
+		return nil
+	}
+	path := configFlag.Value.String()
+	config, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := config["config"]; ok {
+		return fmt.Errorf("config file %s can't set -config", path)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// Apply in a fixed order so errors are reported consistently
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	known := configFlagNames()
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("config file %s: unknown key %q, expected a flag name without the dash", path, name)
+		}
+	}
+
+	for _, name := range names {
+		if fs.Lookup(name) == nil || explicit[name] {
+			continue
+		}
+
+		for _, value := range config[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("config file %s: invalid value %q for %s: %s", path, value, name, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Names of the flags of every command that reads a -config file
+func configFlagNames() map[string]bool {
+	var reason string
+	var labels stringSlice
+	flagSets := []*flag.FlagSet{
+		flagSetOf(newSubmitFlagSet(&options{})),
+		flagSetOf(newGetFlagSet(&options{})),
+		flagSetOf(newDescribeFlagSet(&options{})),
+		flagSetOf(newStopFlagSet(&options{}, &reason)),
+		flagSetOf(newListFlagSet(&options{}, &labels)),
+	}
+
+	names := map[string]bool{}
+	for _, fs := range flagSets {
+		fs.VisitAll(func(f *flag.Flag) {
+			names[f.Name] = true
+		})
+	}
+
+	return names
+}
+
+func flagSetOf(fs *flag.FlagSet, _ []func() error) *flag.FlagSet {
+	return fs
+}
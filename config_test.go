@@ -0,0 +1,139 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestConfigFilePrecedence(t *testing.T) {
+	config := writeTempFile(t, "config.yaml", []byte(`
+api-host: http://file.example.com:1234
+api-token: file-token
+image: alpine:file
+env:
+  - FROM=file
+  - ONLY_IN_FILE=yes
+reason: only a stop flag
+`))
+
+	tests := []struct {
+		name     string
+		args     []string
+		envHost  string
+		envToken string
+		want     *options
+		wantEnv  map[string]models.EnvVarValue
+	}{
+		{
+			name: "defaults",
+			want: &options{apiHost: defaultAPIHost, image: defaultImage},
+		},
+		{
+			name:    "file over defaults",
+			args:    []string{"-config", config},
+			want:    &options{apiHost: "http://file.example.com:1234", apiToken: "file-token", image: "alpine:file"},
+			wantEnv: map[string]models.EnvVarValue{"FROM": "file", "ONLY_IN_FILE": "yes"},
+		},
+		{
+			name:     "environment over file",
+			args:     []string{"-config", config},
+			envHost:  "http://env.example.com:1234",
+			envToken: "env-token",
+			want:     &options{apiHost: "http://env.example.com:1234", apiToken: "env-token", image: "alpine:file"},
+			wantEnv:  map[string]models.EnvVarValue{"FROM": "file", "ONLY_IN_FILE": "yes"},
+		},
+		{
+			name: "flags over file",
+			args: []string{
+				"-config", config, "-api-host", "http://flag.example.com:1234", "-api-token", "flag-token",
+				"-image", "alpine:flag", "-env", "FROM=flag",
+			},
+			want: &options{apiHost: "http://flag.example.com:1234", apiToken: "flag-token", image: "alpine:flag"},
+			// A repeatable flag replaces the file's list
+			wantEnv: map[string]models.EnvVarValue{"FROM": "flag"},
+		},
+		{
+			name: "environment over flags and file",
+			args: []string{
+				"-config", config, "-api-host", "http://flag.example.com:1234", "-api-token", "flag-token",
+				"-image", "alpine:flag", "-env", "FROM=flag",
+			},
+			envHost:  "http://env.example.com:1234",
+			envToken: "env-token",
+			want:     &options{apiHost: "http://env.example.com:1234", apiToken: "env-token", image: "alpine:flag"},
+			wantEnv:  map[string]models.EnvVarValue{"FROM": "flag"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BACALHAU_API_HOST", tt.envHost)
+			t.Setenv("BACALHAU_API_TOKEN", tt.envToken)
+
+			opts := testOptions(t, tt.args...)
+			if opts.apiHost != tt.want.apiHost {
+				t.Errorf("got api host %q, want %q", opts.apiHost, tt.want.apiHost)
+			}
+			if opts.apiToken != tt.want.apiToken {
+				t.Errorf("got api token %q, want %q", opts.apiToken, tt.want.apiToken)
+			}
+			if opts.image != tt.want.image {
+				t.Errorf("got image %q, want %q", opts.image, tt.want.image)
+			}
+			if !maps.Equal(opts.env, tt.wantEnv) {
+				t.Errorf("got env %v, want %v", opts.env, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "misspelt key", config: "memroy: 2Gb\n", want: `unknown key "memroy"`},
+		{name: "invalid value", config: "count: many\n", want: `invalid value "many" for count`},
+		{name: "config key", config: "config: other.yaml\n", want: "can't set -config"},
+		{name: "nested value", config: "image:\n  name: alpine\n", want: "expected a value or a list of values"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := writeTempFile(t, "config.yaml", []byte(tt.config))
+
+			_, err := parseFlags([]string{"-output-dir", t.TempDir(), "-config", config})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected %q error, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestConfigFileSharedByCommands(t *testing.T) {
+	// Keys for other commands' flags are skipped, so get and list can read
+	// the file the default command uses
+	config := writeTempFile(t, "config.yaml", []byte("image: alpine:3\nlimit: 5\nreason: cleanup\n"))
+
+	opts, err := parseListFlags([]string{"-config", config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.limit != 5 {
+		t.Fatalf("got limit %d, want 5 from the file", opts.limit)
+	}
+	if _, _, err := parseGetFlags([]string{"job-1", "-config", config}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, reason, err := parseStopFlags([]string{"job-1", "-config", config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "cleanup" {
+		t.Fatalf("got reason %q, want %q from the file", reason, "cleanup")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+	"gopkg.in/yaml.v3"
+)
+
+// Print a job's full spec, state and executions without waiting on it
+func runDescribe(args []string) int {
+	opts, jobID, err := parseDescribeFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("Failed to parse flags", "error", err)
+		return 1
+	}
+	slog.SetDefault(opts.logger)
+	stopReporting, err := startReporting(opts)
+	if err != nil {
+		slog.Error("Failed to start reporting", "error", err)
+		return 1
+	}
+	defer stopReporting()
+
+	ctx, _, cancel := newRunContext(opts)
+	defer cancel()
+
+	jobs := newJobsClient(opts)
+	jobInfo, err := jobs.Get(ctx, &apimodels.GetJobRequest{
+		JobID:   jobID,
+		Include: "executions",
+	})
+	if err != nil {
+		slog.Error("Failed to get job", "jobID", jobID, "error", err)
+		return 1
+	}
+
+	data, err := encodeJob(jobInfo, opts.outputFormat)
+	if err != nil {
+		slog.Error("Failed to encode job", "jobID", jobID, "error", err)
+		return 1
+	}
+	fmt.Println(string(data))
+
+	return 0
+}
+
+// Encode a job or job API response as indented JSON, or as YAML using the same
+// field names
+func encodeJob(v any, format string) ([]byte, error) {
+	jsonData, err := json.MarshalIndent(v, "", "  ")
+	if err != nil || format != "yaml" {
+		return jsonData, err
+	}
+
+	// JSON is valid YAML, so parse it into nodes to keep the field order and
+	// switch them to block style
+	var doc yaml.Node
+	if err := yaml.Unmarshal(jsonData, &doc); err != nil {
+		return nil, err
+	}
+	setBlockStyle(&doc)
+
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return data[:len(data)-1], nil
+}
+
+// Clear the flow style parsed from JSON so YAML is written one field per line
+func setBlockStyle(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		node.Style = 0
+	}
+	if node.Kind == yaml.ScalarNode && node.Style == yaml.DoubleQuotedStyle {
+		node.Style = 0
+	}
+	for _, child := range node.Content {
+		setBlockStyle(child)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delay before the first download retry, doubled for each further attempt
+const downloadRetryBackoff = 1 * time.Second
+
+// An error worth retrying, such as a dropped connection or a server error
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+// Build the HTTP client used for results downloads. Connecting and waiting for
+// response headers are bounded, while the body may take as long as the run allows.
+func newDownloadClient(connectTimeout, headerTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = headerTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+// Download resultsURL to path, retrying connection errors and 5xx responses with backoff
+func downloadResults(ctx context.Context, resultsURL, path string, opts *options) error {
+	backoff := downloadRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := downloadOnce(ctx, resultsURL, path, opts)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return abortDownload(ctx, path, opts.resume)
+		}
+
+		var retryable retryableError
+		if !errors.As(err, &retryable) || attempt > opts.downloadRetries {
+			return err
+		}
+
+		slog.Warn("Download failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return abortDownload(ctx, path, opts.resume)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Give up on a cancelled download, removing the partial file unless it's kept
+// for -resume to continue later
+func abortDownload(ctx context.Context, path string, resume bool) error {
+	if resume {
+		slog.Info("Download cancelled, keeping partial file to resume", "path", path)
+		return ctx.Err()
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Failed to remove partial download", "path", path, "error", err)
+	}
+
+	return ctx.Err()
+}
+
+// Download resultsURL to path in a single attempt. When resuming, bytes already
+// in path are kept and only the remainder is requested, if the server allows it.
+func downloadOnce(ctx context.Context, resultsURL, path string, opts *options) error {
+	var offset int64
+	if opts.resume {
+		if info, err := os.Stat(path); err == nil {
+			offset = info.Size()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resultsURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating GET request: %s", err.Error())
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := opts.downloadClient.Do(req)
+	if err != nil {
+		return retryableError{fmt.Errorf("error making GET request: %s", err.Error())}
+	}
+	defer func() {
+		// Drain the body so the connection can be reused by the next attempt
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	hash := sha256.New()
+	totalSize := resp.ContentLength
+	fileFlags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("server resumed download at byte %d, expected %d", start, offset)
+		}
+		if err := hashFile(hash, path); err != nil {
+			return err
+		}
+		slog.Info("Resuming download", "path", path, "offset", offset)
+		totalSize = total
+		if totalSize < 0 && resp.ContentLength >= 0 {
+			// The server didn't know the full size, but did say how much is left
+			totalSize = offset + resp.ContentLength
+		}
+		fileFlags = os.O_WRONLY | os.O_APPEND
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The range starts at or past the end, so the file may already be complete
+		_, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || total != offset {
+			return fmt.Errorf("server cannot resume download at byte %d: %s", offset, resp.Status)
+		}
+		if err := hashFile(hash, path); err != nil {
+			return err
+		}
+		return verifyDownload(offset, total, hex.EncodeToString(hash.Sum(nil)), opts.verifySHA256)
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			slog.Info("Server does not support resuming, restarting download", "path", path)
+			offset = 0
+		}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return retryableError{fmt.Errorf("bad status: %s", resp.Status)}
+	default:
+		return fmt.Errorf("bad status: %s: %s", resp.Status, bodySnippet(resp.Body))
+	}
+
+	// An expired or mistyped URL can come back as a 200 error page, which would
+	// otherwise only fail later as a confusing extraction error
+	body := bufio.NewReader(resp.Body)
+	if offset == 0 {
+		if err := checkArchiveResponse(resp.Header.Get("Content-Type"), body); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(path, fileFlags, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating file: %s", err.Error())
+	}
+	defer out.Close()
+
+	// Report progress only to people watching a terminal
+	var src io.Reader = body
+	if !opts.quiet && isTerminal(os.Stderr) {
+		progress := newProgressReader(body, os.Stderr, offset, totalSize)
+		defer progress.finish()
+		src = progress
+	}
+
+	// Write the body to the target, hashing it on the way
+	written, err := io.Copy(io.MultiWriter(out, hash), src)
+	if err != nil {
+		return retryableError{fmt.Errorf("error writing to file: %s", err.Error())}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing to file: %s", err.Error())
+	}
+
+	// Verify the download before it is extracted
+	return verifyDownload(offset+written, totalSize, hex.EncodeToString(hash.Sum(nil)), opts.verifySHA256)
+}
+
+// Reject responses that are clearly a web page or error document rather than
+// an archive, judging by the Content-Type and the first bytes of the body
+func checkArchiveResponse(contentType string, body *bufio.Reader) error {
+	head, _ := body.Peek(512)
+	if sniffArchiveFormat(head) != "" {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	textual := strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "xml") || strings.HasSuffix(mediaType, "json")
+	trimmed := bytes.TrimSpace(head)
+	markup := bytes.HasPrefix(trimmed, []byte("<")) || bytes.HasPrefix(trimmed, []byte("{"))
+	if !textual && !markup {
+		return nil
+	}
+
+	return fmt.Errorf("results URL returned %s instead of an archive, the URL may have expired: %s",
+		describeContentType(contentType), bodySnippet(bytes.NewReader(head)))
+}
+
+func describeContentType(contentType string) string {
+	if contentType == "" {
+		return "a document"
+	}
+
+	return contentType
+}
+
+// Read the start of a response body as a single line for error messages
+func bodySnippet(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 200))
+	snippet := strings.Join(strings.Fields(string(data)), " ")
+	if snippet == "" {
+		return "empty body"
+	}
+
+	return strconv.Quote(snippet)
+}
+
+// Feed the existing content of path into hash
+func hashFile(hash io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading partial download: %s", err.Error())
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("error reading partial download: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Parse the start offset and total size from a Content-Range header such as
+// "bytes 100-199/1000" or "bytes */1000". The total is -1 when the server
+// reports it as unknown.
+func parseContentRange(value string) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	rangePart, totalPart, hasTotal := strings.Cut(spec, "/")
+	if !ok || !hasTotal {
+		return 0, 0, fmt.Errorf("invalid Content-Range header: %q", value)
+	}
+
+	var start int64
+	if rangePart != "*" {
+		startPart, _, _ := strings.Cut(rangePart, "-")
+		var err error
+		start, err = strconv.ParseInt(startPart, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range header: %q", value)
+		}
+	}
+
+	if totalPart == "*" {
+		return start, -1, nil
+	}
+	total, err := strconv.ParseInt(totalPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range header: %q", value)
+	}
+
+	return start, total, nil
+}
+
+// Check the downloaded size against the size reported by the server, when
+// known, and the digest against the expected SHA-256, when pinned
+func verifyDownload(size, expectedSize int64, digest, expectedDigest string) error {
+	// Chunked responses don't report a length, leaving only the digest to check
+	if expectedSize < 0 {
+		slog.Debug("Server did not report the download size, skipping length check", "size", size)
+	}
+	if expectedSize >= 0 && size != expectedSize {
+		return retryableError{fmt.Errorf("incomplete download: received %d of %d bytes", size, expectedSize)}
+	}
+
+	if expectedDigest != "" && digest != expectedDigest {
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", expectedDigest, digest)
+	}
+
+	return nil
+}
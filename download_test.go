@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadRejectsHTMLBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body>Request has expired</body></html>"))
+	}))
+	defer server.Close()
+	opts := testOptions(t)
+	path := filepath.Join(t.TempDir(), "results.tar.gz")
+
+	err := downloadResults(context.Background(), server.URL+"/results.tar.gz", path, opts)
+	if err == nil {
+		t.Fatal("expected an error for an HTML body")
+	}
+	for _, want := range []string{"returned text/html; charset=utf-8 instead of an archive", "Request has expired"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q doesn't mention %q", err, want)
+		}
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("error page was written to disk: %v", err)
+	}
+}
+
+func TestDownloadChunkedResponse(t *testing.T) {
+	archive := gzipBytes(t, tarArchive(t, tarEntry{name: "outputs/output.txt", body: "hello"}))
+	digest := sha256.Sum256(archive)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		// Flushing before the end sends the body chunked, without a length
+		half := len(archive) / 2
+		w.Write(archive[:half])
+		w.(http.Flusher).Flush()
+		w.Write(archive[half:])
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		sha256  string
+		wantErr string
+	}{
+		{name: "no digest"},
+		{name: "matching digest", sha256: hex.EncodeToString(digest[:])},
+		{name: "mismatched digest", sha256: strings.Repeat("0", 64), wantErr: "checksum mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(t, "-verify-sha256", tt.sha256)
+			path := filepath.Join(t.TempDir(), "results.tar.gz")
+
+			err := downloadResults(context.Background(), server.URL+"/results.tar.gz", path, opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q error, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, archive) {
+				t.Fatalf("downloaded %d bytes, want the %d byte archive", len(data), len(archive))
+			}
+		})
+	}
+}
+
+func TestVerifyDownload(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         int64
+		expectedSize int64
+		wantErr      string
+	}{
+		{name: "unknown length", size: 100, expectedSize: -1},
+		{name: "matching length", size: 100, expectedSize: 100},
+		{name: "short", size: 40, expectedSize: 100, wantErr: "incomplete download: received 40 of 100 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDownload(tt.size, tt.expectedSize, "abc", "")
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadClientHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accept the request but never start responding
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	opts := testOptions(t, "-download-retries", "0")
+	opts.downloadClient = newDownloadClient(time.Second, 50*time.Millisecond)
+	path := filepath.Join(t.TempDir(), "results.tar.gz")
+
+	start := time.Now()
+	err := downloadResults(context.Background(), server.URL+"/results.tar.gz", path, opts)
+	if err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Fatalf("expected a header timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("gave up after %s, want about 50ms", elapsed)
+	}
+}
+
+func TestDownloadCancelledMidCopy(t *testing.T) {
+	for _, resume := range []bool{false, true} {
+		t.Run(fmt.Sprintf("resume=%v", resume), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/gzip")
+				w.Header().Set("Content-Length", "1048576")
+				// More than the client peeks at before it starts writing the file
+				w.Write(append(gzipBytes(t, []byte("partial")), make([]byte, 8<<10)...))
+				w.(http.Flusher).Flush()
+				// Stall the rest of the body until the client goes away
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+
+			opts := testOptions(t)
+			opts.resume = resume
+			path := filepath.Join(t.TempDir(), "results.tar.gz")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// Cancel once the first bytes have been written to disk
+			go func() {
+				for ctx.Err() == nil {
+					if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+						cancel()
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+
+			start := time.Now()
+			err := downloadResults(ctx, server.URL+"/results.tar.gz", path, opts)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected the download to be cancelled, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("copy stopped %s after cancelling, want promptly", elapsed)
+			}
+
+			_, err = os.Stat(path)
+			if resume && err != nil {
+				t.Fatalf("partial download wasn't kept for -resume: %v", err)
+			}
+			if !resume && !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("partial download wasn't removed: %v", err)
+			}
+		})
+	}
+}
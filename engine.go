@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Environment variables for WASM tasks are set through the task's Env, like any other engine
+func getWasmEngine(opts *options) *models.SpecConfig {
+	params := map[string]any{
+		"EntryModule": opts.wasmModule,
+		"Parameters":  opts.wasmParams,
+	}
+	if opts.wasmEntrypoint != "" {
+		params["Entrypoint"] = opts.wasmEntrypoint
+	}
+
+	return &models.SpecConfig{
+		Type:   "wasm",
+		Params: params,
+	}
+}
+
+// Check the engine flags are consistent with each other and the inputs
+func validateEngine(opts *options) error {
+	switch opts.engine {
+	case "docker":
+		if opts.workdir != "" && !path.IsAbs(opts.workdir) {
+			return fmt.Errorf("workdir %q must be an absolute container path", opts.workdir)
+		}
+		return nil
+	case "wasm":
+		if opts.workdir != "" {
+			return fmt.Errorf("workdir only applies to -engine docker")
+		}
+		if opts.wasmModule == "" {
+			return fmt.Errorf("wasm-module is required with -engine wasm")
+		}
+		if !slices.Contains(inputTargets(opts), opts.wasmModule) {
+			return fmt.Errorf("wasm-module %s must be the container path of one of the inputs", opts.wasmModule)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid engine %q: expected docker or wasm", opts.engine)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateEngine(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mod.wasm", "lib.wasm"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("\x00asm"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	module := filepath.Join(dir, "mod.wasm")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "docker"},
+		{name: "docker workdir", args: []string{"-workdir", "work"}, wantErr: `workdir "work" must be an absolute container path`},
+		{name: "wasm input", args: []string{"-engine", "wasm", "-input", module + ":/mod/mod.wasm", "-wasm-module", "/mod/mod.wasm"}},
+		{
+			name: "wasm single glob match",
+			args: []string{"-engine", "wasm", "-input-glob", module + ":/mod", "-wasm-module", "/mod/mod.wasm"},
+		},
+		{
+			name: "wasm among glob matches",
+			args: []string{"-engine", "wasm", "-input-glob", filepath.Join(dir, "*.wasm") + ":/mod", "-wasm-module", "/mod/mod.wasm"},
+		},
+		{
+			name:    "wasm module not mounted",
+			args:    []string{"-engine", "wasm", "-input-glob", module + ":/mod", "-wasm-module", "/mod/other.wasm"},
+			wantErr: "wasm-module /mod/other.wasm must be the container path of one of the inputs",
+		},
+		{name: "wasm without module", args: []string{"-engine", "wasm", "-input", module + ":/mod/mod.wasm"}, wantErr: "wasm-module is required with -engine wasm"},
+		{name: "unknown engine", args: []string{"-engine", "jvm"}, wantErr: `invalid engine "jvm": expected docker or wasm`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseFlags(append([]string{"-output-dir", t.TempDir()}, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q error, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Fill in {{.Input}}, {{.InputDir}} and {{.Inputs}} in -entrypoint arguments
+// with the container paths of the job's inputs. Runs once inputs are staged, so
+// globbed and inline files resolve to where they are mounted.
+func renderEntrypoint(opts *options) error {
+	data := entrypointData(getInputSources(opts))
+
+	for i, arg := range opts.entrypoint {
+		if !strings.Contains(arg, "{{") {
+			continue
+		}
+
+		// Referencing anything unknown, or .Input without inputs, is an error
+		tmpl, err := template.New("entrypoint").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return fmt.Errorf("invalid entrypoint template %q: %s", arg, err.Error())
+		}
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, data); err != nil {
+			return fmt.Errorf("invalid entrypoint template %q: %s", arg, err.Error())
+		}
+		opts.entrypoint[i] = rendered.String()
+	}
+
+	return nil
+}
+
+// Values available to entrypoint templates. Input and InputDir refer to the
+// first input and are left out when there are none.
+func entrypointData(sources []*models.InputSource) map[string]any {
+	targets := make([]string, 0, len(sources))
+	for _, source := range sources {
+		targets = append(targets, source.Target)
+	}
+	data := map[string]any{"Inputs": targets}
+	if len(sources) == 0 {
+		return data
+	}
+
+	first := sources[0]
+	data["Input"] = first.Target
+	if isDirectoryInput(first.Source) {
+		data["InputDir"] = first.Target
+	} else {
+		data["InputDir"] = path.Dir(first.Target)
+	}
+
+	return data
+}
+
+// Report whether an input source is mounted as a directory: a host directory
+// or an S3 prefix
+func isDirectoryInput(source *models.SpecConfig) bool {
+	switch source.Type {
+	case "localDirectory":
+		info, err := os.Stat(fmt.Sprint(source.Params["SourcePath"]))
+		return err == nil && info.IsDir()
+	case "s3":
+		key := fmt.Sprint(source.Params["Key"])
+		return key == "" || strings.HasSuffix(key, "/")
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRenderEntrypoint(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTempFile(t, "data.csv", []byte("a,b\n"))
+	fileInput := localInput{hostPath: file, target: "/data/data.csv"}
+	dirInput := localInput{hostPath: dir, target: "/inputs"}
+
+	tests := []struct {
+		name       string
+		inputs     []localInput
+		entrypoint []string
+		want       []string
+		wantErr    string
+	}{
+		{
+			name:       "file input",
+			inputs:     []localInput{fileInput, dirInput},
+			entrypoint: []string{"/bin/sh", "-c", "wc -l {{.Input}} > /outputs/count.txt", "{{.InputDir}}", "{{.Inputs}}"},
+			want:       []string{"/bin/sh", "-c", "wc -l /data/data.csv > /outputs/count.txt", "/data", "[/data/data.csv /inputs]"},
+		},
+		{
+			name:       "directory input",
+			inputs:     []localInput{dirInput, fileInput},
+			entrypoint: []string{"ls", "{{.InputDir}}", "{{index .Inputs 1}}"},
+			want:       []string{"ls", "/inputs", "/data/data.csv"},
+		},
+		{
+			name:       "no templates",
+			entrypoint: []string{"echo", "{not a template}"},
+			want:       []string{"echo", "{not a template}"},
+		},
+		{
+			name:       "unknown key",
+			inputs:     []localInput{fileInput},
+			entrypoint: []string{"cat", "{{.Output}}"},
+			wantErr:    `invalid entrypoint template "{{.Output}}"`,
+		},
+		{
+			name:       "input without inputs",
+			entrypoint: []string{"cat", "{{.Input}}"},
+			wantErr:    `invalid entrypoint template "{{.Input}}"`,
+		},
+		{
+			name:       "unparsable",
+			inputs:     []localInput{fileInput},
+			entrypoint: []string{"cat", "{{.Input"},
+			wantErr:    `invalid entrypoint template "{{.Input"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &options{inputs: tt.inputs, entrypoint: slices.Clone(tt.entrypoint)}
+
+			err := renderEntrypoint(opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q error, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(opts.entrypoint, tt.want) {
+				t.Fatalf("got %q, want %q", opts.entrypoint, tt.want)
+			}
+		})
+	}
+}
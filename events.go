@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A progress milestone, written as one JSON line to -events-file
+type event struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"ts"`
+	JobID string    `json:"jobID,omitempty"`
+	State string    `json:"state,omitempty"`
+	Path  string    `json:"path,omitempty"`
+	URL   string    `json:"url,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Writes events as JSON lines. A nil emitter discards them, so callers don't
+// need to check whether -events-file was given.
+type eventEmitter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// Open path for appending events, or return a nil emitter if path is empty
+func openEventEmitter(path string) (*eventEmitter, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening events file: %s", err.Error())
+	}
+
+	return newEventEmitter(f), nil
+}
+
+func newEventEmitter(w io.Writer) *eventEmitter {
+	return &eventEmitter{w: w, now: time.Now}
+}
+
+// Write an event, stamping it with the current time. Failures to write are
+// ignored so observability never breaks a run.
+func (e *eventEmitter) emit(ev event) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ev.Time = e.now().UTC()
+	jsonData, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	_, _ = e.w.Write(append(jsonData, '\n'))
+}
+
+func (e *eventEmitter) close() {
+	if e == nil {
+		return
+	}
+	if closer, ok := e.w.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestEventsOfSuccessfulRun(t *testing.T) {
+	jobs := newFakeJobs()
+	jobs.submitted = [][]models.JobStateType{{models.JobStateTypeRunning, models.JobStateTypeCompleted}}
+	jobs.results = []*models.SpecConfig{serveResult(t, "exec-1.tar.gz", gzipBytes(t, tarArchive(t,
+		tarEntry{name: "outputs/output.txt", body: "hello"},
+	)))}
+	opts := testOptions(t)
+	var buf bytes.Buffer
+	opts.events = newEventEmitter(&buf)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	opts.events.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	job := getJob(opts)
+	jobID, timeline, err := submitAndRecord(ctx, jobs, &job, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, jobInfo, err := waitForFinalState(ctx, func() {}, jobs, job, jobID, opts, timeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := fetchOutputs(ctx, jobs, jobInfo.Job, opts); code != 0 {
+		t.Fatalf("fetchOutputs exited with %d", code)
+	}
+
+	want := []event{
+		{Event: "submitted", JobID: "job-1"},
+		{Event: "state_change", JobID: "job-1", State: "Running"},
+		{Event: "state_change", JobID: "job-1", State: "Completed"},
+		{Event: "download_started", JobID: "job-1"},
+		{Event: "download_completed", JobID: "job-1"},
+		{Event: "extracted", JobID: "job-1"},
+		{Event: "results_available", JobID: "job-1"},
+	}
+	var got []event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var ev event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event line %q: %s", scanner.Text(), err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d:\n%s", len(got), len(want), buf.String())
+	}
+	for i, ev := range got {
+		if ev.Event != want[i].Event || ev.JobID != want[i].JobID || ev.State != want[i].State || !ev.Time.Equal(now) {
+			t.Fatalf("event %d: got %+v, want %+v at %s", i, ev, want[i], now)
+		}
+	}
+}
+
+func TestReportingStartsAfterParsing(t *testing.T) {
+	eventsFile := filepath.Join(t.TempDir(), "events.jsonl")
+	args := []string{"-output-dir", t.TempDir(), "-events-file", eventsFile, "-metrics-addr", "127.0.0.1:0"}
+
+	// A parse error after the client flags leaves nothing open behind
+	if _, err := parseFlags(append(args, "-engine", "jvm")); err == nil {
+		t.Fatal("expected an error for -engine jvm")
+	}
+	if _, err := os.Stat(eventsFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("events file opened while parsing: %v", err)
+	}
+
+	opts, err := parseFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(eventsFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("events file opened while parsing: %v", err)
+	}
+	if opts.events != nil || opts.metrics != nil {
+		t.Fatal("reporting started while parsing")
+	}
+
+	stopReporting, err := startReporting(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.metrics == nil {
+		t.Fatal("metrics registry not started")
+	}
+	opts.events.emit(event{Event: "submitted", JobID: "job-1"})
+	stopReporting()
+
+	data, err := os.ReadFile(eventsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"event":"submitted"`) {
+		t.Fatalf("got events %q, want the submitted event", data)
+	}
+}
+
+func TestInvalidMetricsAddr(t *testing.T) {
+	_, err := parseFlags([]string{"-output-dir", t.TempDir(), "-metrics-addr", "9090"})
+	if err == nil || !strings.Contains(err.Error(), `invalid metrics-addr "9090"`) {
+		t.Fatalf("expected an invalid metrics-addr error, got %v", err)
+	}
+}
@@ -0,0 +1,791 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits and behavior for extracting result archives
+type extractOptions struct {
+	// Maximum total bytes written across all files
+	maxBytes int64
+	// Maximum number of archive entries
+	maxEntries int
+	// Replace files that already exist instead of failing
+	overwrite bool
+	// Number of goroutines writing small tar entries, 1 to write them in order
+	workers int
+	// Archive format to assume, or empty to detect it
+	format string
+	// Glob patterns selecting which entries to extract, and then which of
+	// those to leave out
+	include []string
+	exclude []string
+}
+
+// Report whether only some entries are extracted, so missing ones are expected
+func (eo extractOptions) filtered() bool {
+	return len(eo.include) > 0 || len(eo.exclude) > 0
+}
+
+// Report whether an entry is left out by -extract-include or -extract-exclude
+func (eo extractOptions) skips(name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if len(eo.include) > 0 && !matchesAny(eo.include, name) {
+		return true
+	}
+
+	return matchesAny(eo.exclude, name)
+}
+
+// Match an entry path against glob patterns. Patterns without a slash match
+// the base name at any depth, the rest the whole path.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		subject := name
+		if !strings.Contains(pattern, "/") {
+			subject = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check -extract-include and -extract-exclude patterns are valid globs
+func checkExtractPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Supported result archive formats
+const (
+	formatTarGz = "tar.gz"
+	formatTar   = "tar"
+	formatZip   = "zip"
+)
+
+// Parse an -archive-format value into one of the supported formats, or empty
+// for auto detection
+func parseArchiveFormat(value string) (string, error) {
+	switch value {
+	case "auto":
+		return "", nil
+	case "targz":
+		return formatTarGz, nil
+	case formatTar, formatZip:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid archive format %q: expected auto, targz, tar or zip", value)
+}
+
+// Extract the archive at src into dst as the format given by -archive-format,
+// or else the one detected from the file's contents or, failing that, from the
+// extension of the URL it came from
+func extractArchive(src, dst, sourceURL string, eo extractOptions) error {
+	format := eo.format
+	if format == "" {
+		var err error
+		if format, err = detectArchiveFormat(src, sourceURL); err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case formatTar:
+		return extractTar(src, dst, eo)
+	case formatZip:
+		return extractZip(src, dst, eo)
+	default:
+		return extractTarGz(src, dst, sourceURL, eo)
+	}
+}
+
+// Identify an archive by its magic bytes, falling back to the URL extension
+func detectArchiveFormat(src, sourceURL string) (string, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// The tar magic sits at offset 257 of the first header block
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	if format := sniffArchiveFormat(header); format != "" {
+		return format, nil
+	}
+
+	return archiveFormatFromURL(sourceURL)
+}
+
+// Identify an archive by the extension of the URL it came from
+func archiveFormatFromURL(sourceURL string) (string, error) {
+	name := strings.ToLower(resultFileName(sourceURL))
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return formatTarGz, nil
+	case strings.HasSuffix(name, ".tar"):
+		return formatTar, nil
+	case strings.HasSuffix(name, ".zip"):
+		return formatZip, nil
+	}
+
+	return "", fmt.Errorf("unrecognized archive format for %s, expected tar.gz, tar or zip", resultFileName(sourceURL))
+}
+
+// Identify an archive from its first bytes, or return empty if unknown
+func sniffArchiveFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return formatTarGz
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return formatZip
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return formatTar
+	}
+
+	return ""
+}
+
+// Extract a gzipped tar, or write the decompressed stream to a single file when
+// the job published a plain gzipped file instead
+func extractTarGz(src, dst, sourceURL string, eo extractOptions) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return extractTarGzStream(file, dst, sourceURL, eo)
+}
+
+// Extract a gzipped tar, or a plain gzipped file, read from r
+func extractTarGzStream(r io.Reader, dst, sourceURL string, eo extractOptions) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	br := bufio.NewReader(gzr)
+	block, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !isTarHeader(block) {
+		return extractGzipFile(br, dst, gzipFileName(gzr.Name, sourceURL), gzr.ModTime, eo)
+	}
+
+	return extractTarStream(br, dst, eo)
+}
+
+// Report whether a block is the start of a tar stream. A tar is at least two
+// blocks long, and anything else fails the header checksum.
+func isTarHeader(block []byte) bool {
+	if len(block) < 512 {
+		return false
+	}
+	_, err := tar.NewReader(bytes.NewReader(block)).Next()
+
+	return !errors.Is(err, tar.ErrHeader)
+}
+
+// Name the file decompressed from a plain gzip result after the name recorded
+// by gzip, or else after the result with its .gz extension dropped
+func gzipFileName(headerName, sourceURL string) string {
+	if name := filepath.Base(filepath.FromSlash(headerName)); headerName != "" && name != "." && name != ".." {
+		return name
+	}
+
+	name := strings.TrimSuffix(resultFileName(sourceURL), ".gz")
+	if name == "" || name == "." || name == "/" {
+		return "result"
+	}
+
+	return name
+}
+
+// Write a decompressed gzip stream to a single file in dst
+func extractGzipFile(r io.Reader, dst, name string, modTime time.Time, eo extractOptions) error {
+	e, err := newExtractor(dst, eo)
+	if err != nil {
+		return err
+	}
+	target, err := e.next(name)
+	if err != nil {
+		return err
+	}
+	if eo.skips(name) {
+		return e.finish()
+	}
+	if err := e.writeFile(target, 0644, modTime, r); err != nil {
+		return err
+	}
+
+	return e.finish()
+}
+
+func extractTar(src, dst string, eo extractOptions) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return extractTarStream(file, dst, eo)
+}
+
+// Tracks limits and deferred directory modes while extracting into dst
+type extractor struct {
+	dst        string
+	opts       extractOptions
+	totalBytes int64
+	entries    int
+	// Directory modes are applied once everything is extracted, so read-only
+	// directories don't block writing their contents
+	dirModes map[string]os.FileMode
+	// Directory times are applied last too, since writing into a directory
+	// changes its modification time
+	dirTimes map[string]time.Time
+	// Writers for small files, and the paths handed to them, when extracting
+	// concurrently
+	pool   *writePool
+	queued map[string]bool
+}
+
+func newExtractor(dst string, eo extractOptions) (*extractor, error) {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, err
+	}
+
+	return &extractor{
+		dst:      dst,
+		opts:     eo,
+		dirModes: map[string]os.FileMode{},
+		dirTimes: map[string]time.Time{},
+	}, nil
+}
+
+// Count an entry against the limit and resolve its path under dst
+func (e *extractor) next(name string) (string, error) {
+	e.entries++
+	if e.entries > e.opts.maxEntries {
+		return "", fmt.Errorf("archive has more than %d entries", e.opts.maxEntries)
+	}
+
+	return sanitizeArchivePath(e.dst, name)
+}
+
+func (e *extractor) mkdir(target string, mode os.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	e.dirModes[target] = mode.Perm()
+	if !modTime.IsZero() {
+		e.dirTimes[target] = modTime
+	}
+
+	return nil
+}
+
+// Write a regular file, failing once the total size limit is exceeded
+func (e *extractor) writeFile(target string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	if err := e.settle(target); err != nil {
+		return err
+	}
+	f, err := createFile(target, mode, e.opts.overwrite)
+	if err != nil {
+		return err
+	}
+	// Read at most one byte past the remaining budget to detect overruns
+	remaining := e.opts.maxBytes - e.totalBytes
+	written, err := io.Copy(f, io.LimitReader(r, remaining+1))
+	if err != nil {
+		f.abort()
+		return err
+	}
+	e.totalBytes += written
+	if e.totalBytes > e.opts.maxBytes {
+		f.abort()
+		return fmt.Errorf("archive expands to more than %d bytes", e.opts.maxBytes)
+	}
+
+	return f.commit(modTime)
+}
+
+// Write a sparse file, leaving its runs of zeros as holes rather than writing
+// them out. Holes still count towards the size limit.
+func (e *extractor) writeSparseFile(target string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	if err := e.settle(target); err != nil {
+		return err
+	}
+	f, err := createFile(target, mode, e.opts.overwrite)
+	if err != nil {
+		return err
+	}
+	remaining := e.opts.maxBytes - e.totalBytes
+	written, err := io.CopyBuffer(holeWriter{f.File}, io.LimitReader(r, remaining+1), make([]byte, 32<<10))
+	// Seeking past a trailing hole doesn't extend the file
+	if err == nil {
+		err = f.Truncate(written)
+	}
+	if err != nil {
+		f.abort()
+		return err
+	}
+	e.totalBytes += written
+	if e.totalBytes > e.opts.maxBytes {
+		f.abort()
+		return fmt.Errorf("archive expands to more than %d bytes", e.opts.maxBytes)
+	}
+
+	return f.commit(modTime)
+}
+
+// Report whether a tar entry is a sparse file, in the old GNU format or as
+// GNU sparse PAX records. The tar reader fills in the holes with zeros.
+func isSparseEntry(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Size of the blocks holeWriter checks for zeros
+const holeBlockSize = 4096
+
+// Writer that seeks over blocks of zeros instead of writing them, so the file
+// system can leave them unallocated
+type holeWriter struct {
+	f *os.File
+}
+
+func (w holeWriter) Write(p []byte) (int, error) {
+	var zeros [holeBlockSize]byte
+	written := 0
+	for len(p) > 0 {
+		block := p[:min(len(p), holeBlockSize)]
+		var err error
+		if bytes.Equal(block, zeros[:len(block)]) {
+			_, err = w.f.Seek(int64(len(block)), io.SeekCurrent)
+		} else {
+			_, err = w.f.Write(block)
+		}
+		if err != nil {
+			return written, err
+		}
+		written += len(block)
+		p = p[len(block):]
+	}
+
+	return written, nil
+}
+
+// Hand a small file to the write pool, reading it into memory first. Files
+// too large to buffer, or any file without a pool, are written directly.
+func (e *extractor) queueFile(target string, mode os.FileMode, modTime time.Time, size int64, r io.Reader) error {
+	if e.pool == nil || size > maxBufferedFileSize {
+		return e.writeFile(target, mode, modTime, r)
+	}
+	if err := e.settle(target); err != nil {
+		return err
+	}
+
+	remaining := e.opts.maxBytes - e.totalBytes
+	data, err := io.ReadAll(io.LimitReader(r, remaining+1))
+	if err != nil {
+		return err
+	}
+	e.totalBytes += int64(len(data))
+	if e.totalBytes > e.opts.maxBytes {
+		return fmt.Errorf("archive expands to more than %d bytes", e.opts.maxBytes)
+	}
+
+	e.queued[target] = true
+	return e.pool.submit(bufferedFile{target: target, mode: mode, modTime: modTime, data: data})
+}
+
+// Wait for pending writes when target is one of them, so entries for the same
+// path are still applied in archive order
+func (e *extractor) settle(target string) error {
+	if e.pool == nil || !e.queued[target] {
+		return nil
+	}
+
+	return e.settleAll()
+}
+
+// Wait for every pending write
+func (e *extractor) settleAll() error {
+	if e.pool == nil {
+		return nil
+	}
+	clear(e.queued)
+
+	return e.pool.flush()
+}
+
+// A file being extracted. It's written under a temporary name in the same
+// directory and renamed over its target once complete, so a crash or failed
+// write never leaves a truncated file at the target.
+type extractedFile struct {
+	*os.File
+	target    string
+	mode      os.FileMode
+	overwrite bool
+}
+
+// Create a file to extract into. Existing files are never replaced unless
+// overwriting, and then none of their old content lingers.
+func createFile(target string, mode os.FileMode, overwrite bool) (*extractedFile, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	if err := checkFileFree(target, overwrite); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &extractedFile{File: f, target: target, mode: mode, overwrite: overwrite}, nil
+}
+
+func checkFileFree(target string, overwrite bool) error {
+	if overwrite {
+		return nil
+	}
+	_, err := os.Lstat(target)
+	if err == nil {
+		return fmt.Errorf("refusing to overwrite existing file %s, pass -overwrite to replace it", target)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Apply the file's mode and modification time and move it into place
+func (f *extractedFile) commit(modTime time.Time) error {
+	err := f.Close()
+	if err == nil {
+		err = os.Chmod(f.Name(), f.mode)
+	}
+	if err == nil {
+		err = setModTime(f.Name(), modTime)
+	}
+	// Another entry may have claimed the target while this one was written
+	if err == nil {
+		err = checkFileFree(f.target, f.overwrite)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.target)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// Discard a file that couldn't be written in full
+func (f *extractedFile) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func (e *extractor) symlink(target, name, linkname string) error {
+	if err := checkSymlinkTarget(e.dst, name, linkname); err != nil {
+		return err
+	}
+	// Files still being written were checked before the link existed, so
+	// they mustn't resolve their paths through it
+	if err := e.settleAll(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	return os.Symlink(linkname, target)
+}
+
+func (e *extractor) finish() error {
+	if e.pool != nil {
+		if err := e.pool.close(); err != nil {
+			return err
+		}
+	}
+
+	for dir, mode := range e.dirModes {
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+	}
+	for dir, modTime := range e.dirTimes {
+		if err := setModTime(dir, modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore the modification time recorded in the archive, when it has one
+func setModTime(target string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+
+	return os.Chtimes(target, modTime, modTime)
+}
+
+func extractTarStream(r io.Reader, dst string, eo extractOptions) error {
+	e, err := newExtractor(dst, eo)
+	if err != nil {
+		return err
+	}
+	// Tar can only be read in order, but writing many small files can be
+	// spread across goroutines
+	if eo.workers > 1 {
+		e.pool = newWritePool(eo.workers, eo.overwrite)
+		e.queued = map[string]bool{}
+		defer e.pool.close()
+	}
+
+	if err := e.readTar(tar.NewReader(r)); err != nil {
+		// A pending write may have failed on an earlier entry, report that first
+		if e.pool != nil {
+			if poolErr := e.pool.close(); poolErr != nil {
+				return poolErr
+			}
+		}
+		return err
+	}
+
+	return e.finish()
+}
+
+// Extract each entry of a tar stream in turn
+func (e *extractor) readTar(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := e.next(header.Name)
+		if err != nil {
+			return err
+		}
+		// Hard links to a file that was left out have nothing to point at
+		if e.opts.skips(header.Name) || (header.Typeflag == tar.TypeLink && e.opts.skips(header.Linkname)) {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := e.mkdir(target, header.FileInfo().Mode(), header.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeGNUSparse:
+			if isSparseEntry(header) {
+				if err := e.writeSparseFile(target, os.FileMode(header.Mode), header.ModTime, tr); err != nil {
+					return err
+				}
+			} else if err := e.queueFile(target, os.FileMode(header.Mode), header.ModTime, header.Size, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := e.symlink(target, header.Name, header.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := sanitizeArchivePath(e.dst, header.Linkname)
+			if err != nil {
+				return err
+			}
+			// The link source may still be waiting to be written
+			if err := e.settle(source); err != nil {
+				return err
+			}
+			if err := e.settle(target); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// PAX global headers carry metadata only
+		default:
+			return fmt.Errorf("unsupported entry type %q in archive: %s", header.Typeflag, header.Name)
+		}
+	}
+}
+
+func extractZip(src, dst string, eo extractOptions) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	e, err := newExtractor(dst, eo)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		target, err := e.next(f.Name)
+		if err != nil {
+			return err
+		}
+		if e.opts.skips(f.Name) {
+			continue
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.mkdir(target, mode, f.Modified)
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(e, f, target)
+		case mode.IsRegular():
+			err = extractZipFile(e, f, target)
+		default:
+			err = fmt.Errorf("unsupported entry type %s in archive: %s", mode.Type(), f.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.finish()
+}
+
+func extractZipFile(e *extractor, f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return e.writeFile(target, f.Mode().Perm(), f.Modified, rc)
+}
+
+// Zip stores a symlink's target as the entry's contents
+func extractZipSymlink(e *extractor, f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	linkname, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+
+	return e.symlink(target, f.Name, string(linkname))
+}
+
+// Resolve an archive entry name under dst, rejecting entries that would escape
+// it. Nothing is created beneath a symlink already extracted, since the path
+// alone can't show where a chain of links leads.
+func sanitizeArchivePath(dst, name string) (string, error) {
+	target := filepath.Join(dst, name)
+	if !isWithinDir(dst, target) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+
+	rel, err := filepath.Rel(dst, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return target, err
+	}
+	dir := dst
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("illegal path in archive: %s is beneath a symlink", name)
+		}
+	}
+
+	return target, nil
+}
+
+// Reject symlinks whose target would resolve outside dst. Parent directory
+// references are only allowed before the rest of the target, as a later one
+// would climb out of wherever an extracted symlink among them points.
+func checkSymlinkTarget(dst, name, linkname string) error {
+	resolved := filepath.Join(dst, filepath.Dir(name), linkname)
+	if filepath.IsAbs(linkname) || !isWithinDir(dst, resolved) {
+		return fmt.Errorf("illegal symlink in archive: %s -> %s", name, linkname)
+	}
+
+	climbing := true
+	for _, part := range strings.Split(filepath.ToSlash(linkname), "/") {
+		switch {
+		case part == "..":
+			if !climbing {
+				return fmt.Errorf("illegal symlink in archive: %s -> %s", name, linkname)
+			}
+		case part != "" && part != ".":
+			climbing = false
+		}
+	}
+
+	return nil
+}
+
+// Report whether path is dir or lies under it
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An entry of a test archive. Entries without a type are regular files.
+type tarEntry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+	modTime  time.Time
+}
+
+func tarArchive(t testing.TB, entries ...tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			ModTime:  entry.modTime,
+		}
+		switch entry.typeflag {
+		case 0:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(entry.body))
+		case tar.TypeDir:
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipBytes(t testing.TB, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Write data to a file in a new temporary directory and return its path
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func testExtractOptions() extractOptions {
+	return extractOptions{maxBytes: 1 << 20, maxEntries: 1000, workers: 1}
+}
+
+func TestExtractArchiveRejectsEscapingPath(t *testing.T) {
+	src := writeTempFile(t, "results.tar.gz", gzipBytes(t, tarArchive(t,
+		tarEntry{name: "ok.txt", body: "fine"},
+		tarEntry{name: "../escape.txt", body: "escaped"},
+	)))
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "outputs")
+
+	err := extractArchive(src, dst, "", testExtractOptions())
+	if err == nil || !strings.Contains(err.Error(), "illegal path in archive") {
+		t.Fatalf("expected illegal path error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("escaping entry was written outside the output directory: %v", err)
+	}
+}
+
+func TestExtractTarRejectsChainedSymlinks(t *testing.T) {
+	// d/x points back at dst, so d/x/y -> .. points at its parent, yet each
+	// link looks contained when only the path strings are compared
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		want    string
+	}{
+		{
+			name: "file beneath chained links",
+			entries: []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/x", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "d/x/y", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "d/x/y/evil.txt", body: "escaped"},
+			},
+			want: "illegal path in archive",
+		},
+		{
+			name: "directory beneath a link",
+			entries: []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/x", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "d/x/sub/", typeflag: tar.TypeDir},
+			},
+			want: "illegal path in archive",
+		},
+		{
+			name: "climbing out of a link",
+			entries: []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/x", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "up", typeflag: tar.TypeSymlink, linkname: "d/x/../.."},
+				{name: "up/evil.txt", body: "escaped"},
+			},
+			want: "illegal symlink in archive",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, workers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s with %d workers", tt.name, workers), func(t *testing.T) {
+				parent := t.TempDir()
+				dst := filepath.Join(parent, "outputs")
+				eo := testExtractOptions()
+				eo.workers = workers
+
+				err := extractTarStream(bytes.NewReader(tarArchive(t, tt.entries...)), dst, eo)
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("expected %q error, got %v", tt.want, err)
+				}
+				for _, name := range []string{"evil.txt", "sub"} {
+					if _, err := os.Stat(filepath.Join(parent, name)); !errors.Is(err, fs.ErrNotExist) {
+						t.Fatalf("%s was created outside the output directory: %v", name, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractTarKeepsContainedSymlinks(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "outputs")
+	archive := tarArchive(t,
+		tarEntry{name: "a/", typeflag: tar.TypeDir},
+		tarEntry{name: "a/b/", typeflag: tar.TypeDir},
+		tarEntry{name: "a/b/data.txt", body: "data"},
+		tarEntry{name: "a/c/", typeflag: tar.TypeDir},
+		tarEntry{name: "a/c/latest", typeflag: tar.TypeSymlink, linkname: "../b/data.txt"},
+		tarEntry{name: "b", typeflag: tar.TypeSymlink, linkname: "a/b"},
+	)
+	if err := extractTarStream(bytes.NewReader(archive), dst, testExtractOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a/c/latest", "b/data.txt"} {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "data" {
+			t.Fatalf("%s: got %q, want %q", name, data, "data")
+		}
+	}
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	archive := tarArchive(t, tarEntry{name: "output.txt", body: "new"})
+
+	tests := []struct {
+		name      string
+		overwrite bool
+		want      string
+		wantErr   string
+	}{
+		{name: "refuses by default", want: "previous content", wantErr: "refusing to overwrite existing file"},
+		{name: "replaces with overwrite", overwrite: true, want: "new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			target := filepath.Join(dst, "output.txt")
+			if err := os.WriteFile(target, []byte("previous content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			eo := testExtractOptions()
+			eo.overwrite = tt.overwrite
+
+			err := extractTarStream(bytes.NewReader(archive), dst, eo)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected %q error, got %v", tt.wantErr, err)
+			}
+
+			// No trace of the longer old content may be left after the new
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Fatalf("got %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractKeepsModTimes(t *testing.T) {
+	dirTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fileTime := time.Date(2023, 6, 7, 8, 9, 10, 0, time.UTC)
+	archive := tarArchive(t,
+		tarEntry{name: "logs/", typeflag: tar.TypeDir, modTime: dirTime},
+		tarEntry{name: "logs/run.log", body: "done", modTime: fileTime},
+	)
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "outputs")
+			eo := testExtractOptions()
+			eo.workers = workers
+			if err := extractTarStream(bytes.NewReader(archive), dst, eo); err != nil {
+				t.Fatal(err)
+			}
+
+			// The directory's time is set after the file in it is written
+			for name, want := range map[string]time.Time{"logs": dirTime, "logs/run.log": fileTime} {
+				info, err := os.Stat(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.ModTime().Equal(want) {
+					t.Fatalf("%s: got mtime %s, want %s", name, info.ModTime(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTarGzStreamPlainFile(t *testing.T) {
+	text := strings.Repeat("not a tar archive\n", 100)
+
+	tests := []struct {
+		name       string
+		headerName string
+		text       string
+		want       string
+	}{
+		{name: "named by gzip", headerName: "notes.txt", want: "notes.txt"},
+		{name: "shorter than a tar block", headerName: "notes.txt", text: "short\n", want: "notes.txt"},
+		{name: "named by gzip with a path", headerName: "../tmp/notes.txt", want: "notes.txt"},
+		{name: "named by the URL", want: "report.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.text == "" {
+				tt.text = text
+			}
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			gw.Name = tt.headerName
+			if _, err := gw.Write([]byte(tt.text)); err != nil {
+				t.Fatal(err)
+			}
+			if err := gw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(t.TempDir(), "outputs")
+
+			err := extractTarGzStream(&buf, dst, "http://localhost/results/report.csv.gz", testExtractOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != tt.want {
+				t.Fatalf("got entries %v, want only %s", entries, tt.want)
+			}
+			data, err := os.ReadFile(filepath.Join(dst, tt.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.text {
+				t.Fatalf("got %q, want the decompressed text", data)
+			}
+		})
+	}
+}
+
+func TestExtractIncludeExclude(t *testing.T) {
+	archive := tarArchive(t,
+		tarEntry{name: "outputs/", typeflag: tar.TypeDir},
+		tarEntry{name: "outputs/result.csv", body: "a,b"},
+		tarEntry{name: "outputs/logs/", typeflag: tar.TypeDir},
+		tarEntry{name: "outputs/logs/run.log", body: "ran"},
+		tarEntry{name: "outputs/logs/debug.log", body: "debug"},
+		tarEntry{name: "stdout", body: "out"},
+	)
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "everything",
+			want: []string{"outputs/logs/debug.log", "outputs/logs/run.log", "outputs/result.csv", "stdout"},
+		},
+		{
+			name:    "base name at any depth",
+			include: []string{"*.log"},
+			want:    []string{"outputs/logs/debug.log", "outputs/logs/run.log"},
+		},
+		{
+			name:    "whole path",
+			include: []string{"outputs/*"},
+			want:    []string{"outputs/result.csv"},
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"outputs/logs/*", "stdout"},
+			want:    []string{"outputs/result.csv"},
+		},
+		{
+			name:    "exclude after include",
+			include: []string{"*.log", "*.csv"},
+			exclude: []string{"debug.*"},
+			want:    []string{"outputs/logs/run.log", "outputs/result.csv"},
+		},
+		{
+			name:    "no matches",
+			include: []string{"*.json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "outputs")
+			eo := testExtractOptions()
+			eo.include, eo.exclude = tt.include, tt.exclude
+			if err := extractTarStream(bytes.NewReader(archive), dst, eo); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(dst, path)
+				got = append(got, filepath.ToSlash(rel))
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Tar holding one file in the GNU PAX 1.0 sparse format, with data blocks at
+// the given offsets and holes everywhere else. archive/tar can read sparse
+// files but not write them, so the extended header is built by hand.
+func sparseTarArchive(t *testing.T, name string, size int64, data map[int64]string) []byte {
+	t.Helper()
+
+	offsets := slices.Sorted(maps.Keys(data))
+	sparseMap := fmt.Sprintf("%d\n", len(offsets))
+	var fragments string
+	for _, offset := range offsets {
+		sparseMap += fmt.Sprintf("%d\n%d\n", offset, len(data[offset]))
+		fragments += data[offset]
+	}
+	sparseMap += strings.Repeat("\x00", (512-len(sparseMap)%512)%512)
+
+	var records string
+	for _, record := range [][2]string{
+		{"GNU.sparse.major", "1"},
+		{"GNU.sparse.minor", "0"},
+		{"GNU.sparse.name", name},
+		{"GNU.sparse.realsize", fmt.Sprint(size)},
+	} {
+		// Each record's length includes the digits of the length itself
+		body := " " + record[0] + "=" + record[1] + "\n"
+		length := len(body) + 1
+		for len(fmt.Sprint(length))+len(body) != length {
+			length++
+		}
+		records += fmt.Sprint(length) + body
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range []struct {
+		name string
+		body string
+	}{
+		{"PaxHeaders/" + name, records},
+		{"GNUSparseFile.0/" + name, sparseMap + fragments},
+	} {
+		header := &tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(entry.body)), Format: tar.FormatUSTAR}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Turn the first entry into the extended header of the second
+	archive := buf.Bytes()
+	archive[156] = tar.TypeXHeader
+	copy(archive[148:156], "        ")
+	var sum int64
+	for _, b := range archive[:512] {
+		sum += int64(b)
+	}
+	copy(archive[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return archive
+}
+
+func TestExtractSparseFile(t *testing.T) {
+	const size = 3 << 20
+	data := map[int64]string{
+		0:       strings.Repeat("a", 4096),
+		2 << 20: strings.Repeat("b", 4096),
+	}
+	archive := sparseTarArchive(t, "disk.img", size, data)
+	header, err := tar.NewReader(bytes.NewReader(archive)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "disk.img" || !isSparseEntry(header) {
+		t.Fatalf("test archive holds %s, which isn't a sparse disk.img", header.Name)
+	}
+
+	t.Run("holes filled with zeros", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "outputs")
+		eo := testExtractOptions()
+		eo.maxBytes = 4 << 20
+		if err := extractTarStream(bytes.NewReader(archive), dst, eo); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(filepath.Join(dst, "disk.img"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make([]byte, size)
+		for offset, block := range data {
+			copy(want[offset:], block)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("got %d bytes that differ from the %d expected", len(got), len(want))
+		}
+	})
+
+	t.Run("holes count towards the size limit", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "outputs")
+		eo := testExtractOptions()
+		eo.maxBytes = 2 << 20
+		err := extractTarStream(bytes.NewReader(archive), dst, eo)
+		if err == nil || !strings.Contains(err.Error(), "archive expands to more than") {
+			t.Fatalf("expected the size limit to be exceeded, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "disk.img")); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("oversized file was left behind: %v", err)
+		}
+	})
+}
+
+// Reader that fails after handing out part of its data, like a dropped connection
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+func TestExtractInterruptedCopy(t *testing.T) {
+	errDropped := errors.New("connection dropped")
+
+	for _, overwrite := range []bool{false, true} {
+		t.Run(fmt.Sprintf("overwrite=%v", overwrite), func(t *testing.T) {
+			dst := t.TempDir()
+			target := filepath.Join(dst, "output.bin")
+			if overwrite {
+				if err := os.WriteFile(target, []byte("previous content"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			eo := testExtractOptions()
+			eo.overwrite = overwrite
+			e, err := newExtractor(dst, eo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &failingReader{data: bytes.Repeat([]byte("x"), 64<<10), err: errDropped}
+			if err := e.writeFile(target, 0644, time.Time{}, r); !errors.Is(err, errDropped) {
+				t.Fatalf("expected the copy to fail, got %v", err)
+			}
+
+			// Neither a truncated file under the final name nor a temporary one
+			// is left, and a file being replaced keeps its old content
+			entries, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !overwrite {
+				if len(entries) != 0 {
+					t.Fatalf("got %v after the interrupted copy, want nothing", entries)
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].Name() != "output.bin" {
+				t.Fatalf("got %v after the interrupted copy, want only output.bin", entries)
+			}
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "previous content" {
+				t.Fatalf("got %q, want the previous content", data)
+			}
+		})
+	}
+}
+
+func TestExtractTruncatedTar(t *testing.T) {
+	archive := tarArchive(t,
+		tarEntry{name: "first.txt", body: "complete"},
+		tarEntry{name: "second.bin", body: strings.Repeat("x", 64<<10)},
+	)
+	// Cut off part way through the second file's content
+	archive = archive[:len(archive)-32<<10]
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			dst := t.TempDir()
+			eo := testExtractOptions()
+			eo.workers = workers
+
+			if err := extractTarStream(bytes.NewReader(archive), dst, eo); err == nil {
+				t.Fatal("expected the truncated archive to fail")
+			}
+			entries, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "first.txt" {
+				t.Fatalf("got %v, want only the complete first.txt", entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// Lines of stderr to include when reporting a failed execution
+const failureStderrLines = 10
+
+// The job's state message is often generic, so report why each execution
+// failed, with the container's exit code and the end of its stderr
+func logFailedExecutions(jobInfo *apimodels.GetJobResponse) {
+	if jobInfo.Executions == nil {
+		return
+	}
+
+	for _, execution := range jobInfo.Executions.Items {
+		output := execution.RunOutput
+		failed := execution.ComputeState.StateType == models.ExecutionStateFailed
+		if !failed && (output == nil || (output.ExitCode == 0 && output.ErrorMsg == "")) {
+			continue
+		}
+
+		attrs := []any{
+			"jobID", execution.JobID,
+			"executionID", execution.ID,
+			"nodeID", execution.NodeID,
+		}
+		if execution.ComputeState.Message != "" {
+			attrs = append(attrs, "message", execution.ComputeState.Message)
+		}
+		if output != nil {
+			attrs = append(attrs, "exitCode", output.ExitCode)
+			if output.ErrorMsg != "" {
+				attrs = append(attrs, "error", output.ErrorMsg)
+			}
+			if stderr := tailLines(output.STDERR, failureStderrLines); stderr != "" {
+				attrs = append(attrs, "stderr", stderr)
+			}
+		}
+		slog.Error("Execution failed", attrs...)
+	}
+}
+
+// Get the last n lines of s, without a trailing newline
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
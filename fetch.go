@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// A result archive to download and where to extract it
+type resultDownload struct {
+	name        string
+	url         string
+	archivePath string
+	dest        string
+}
+
+// Download a result archive, extract it unless -no-extract is given and remove
+// it unless it should be kept. Returns the size of the downloaded archive.
+func fetchResult(ctx context.Context, jobID string, download resultDownload, opts *options) (int64, error) {
+	if opts.streamExtract {
+		return streamResult(ctx, jobID, download, opts)
+	}
+
+	opts.events.emit(event{Event: "download_started", JobID: jobID, Path: download.archivePath, URL: redactURL(download.url)})
+	if err := downloadResults(ctx, download.url, download.archivePath, opts); err != nil {
+		return 0, err
+	}
+	opts.events.emit(event{Event: "download_completed", JobID: jobID, Path: download.archivePath})
+	info, err := os.Stat(download.archivePath)
+	if err != nil {
+		return 0, fmt.Errorf("error reading results archive: %s", err.Error())
+	}
+	opts.metrics.downloaded(info.Size())
+	if opts.noExtract {
+		return info.Size(), nil
+	}
+
+	// Extract the archive
+	if err := extractArchive(download.archivePath, download.dest, download.url, opts.extract); err != nil {
+		return 0, fmt.Errorf("error extracting results archive: %s", err.Error())
+	}
+	opts.events.emit(event{Event: "extracted", JobID: jobID, Path: download.dest})
+
+	if !opts.keepArchive {
+		if err := os.Remove(download.archivePath); err != nil {
+			return 0, fmt.Errorf("error removing results archive: %s", err.Error())
+		}
+		slog.Debug("Removed results archive", "path", download.archivePath)
+	}
+
+	return info.Size(), nil
+}
+
+// Extract a result archive as it downloads, with -stream-extract. Returns the
+// number of bytes downloaded.
+func streamResult(ctx context.Context, jobID string, download resultDownload, opts *options) (int64, error) {
+	opts.events.emit(event{Event: "download_started", JobID: jobID, Path: download.dest, URL: redactURL(download.url)})
+	downloaded, err := streamExtractResults(ctx, download.url, download.dest, opts)
+	if err != nil {
+		return 0, err
+	}
+	opts.metrics.downloaded(downloaded)
+	opts.events.emit(event{Event: "download_completed", JobID: jobID, Path: download.dest})
+	opts.events.emit(event{Event: "extracted", JobID: jobID, Path: download.dest})
+
+	return downloaded, nil
+}
+
+// Fetch several results with at most -download-concurrency at once. The first
+// failure cancels the rest, and every failure is reported in the returned error.
+// Returns the total size of the downloaded archives.
+func fetchResults(ctx context.Context, jobID string, downloads []resultDownload, opts *options) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Progress bars from concurrent downloads would garble each other
+	workerOpts := *opts
+	if len(downloads) > 1 && opts.downloadConcurrency > 1 {
+		workerOpts.quiet = true
+	}
+
+	sizes := make([]int64, len(downloads))
+	errs := make([]error, len(downloads))
+	slots := make(chan struct{}, opts.downloadConcurrency)
+	var wg sync.WaitGroup
+	for i, download := range downloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			size, err := fetchResult(ctx, jobID, download, &workerOpts)
+			if err == nil {
+				sizes[i] = size
+				return
+			}
+			// Downloads cut short by another failure aren't failures of their own
+			if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+				return
+			}
+			errs[i] = fmt.Errorf("error retrieving result %s: %s", download.name, err.Error())
+			cancel()
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+
+	return total, nil
+}
@@ -0,0 +1,996 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	defaultAPIHost = "http://localhost:1234"
+	defaultImage   = "ubuntu:latest"
+)
+
+var defaultEntrypoint = []string{
+	"/bin/sh",
+	"-c",
+	"cat /tmp/input.txt > /outputs/output.txt",
+}
+
+// Options collected from command line flags and environment variables
+type options struct {
+	// Client
+	apiHost     string
+	apiToken    string
+	tlsCAFile   string
+	tlsInsecure bool
+	tlsConfig   *tls.Config
+	timeout     time.Duration
+	logger      *slog.Logger
+	logLevel    *slog.LevelVar
+	eventsFile  string
+	metricsAddr string
+	events      *eventEmitter
+	metrics     *metricsRegistry
+
+	// Job
+	jobFile         *models.Job
+	jobOverrides    []jobOption
+	name            string
+	taskName        string
+	namespace       string
+	labels          map[string]string
+	constraints     []*models.LabelSelectorRequirement
+	jobType         string
+	count           int
+	priority        int
+	inputs          []localInput
+	s3Inputs        []s3Input
+	urlInputs       []urlInput
+	ipfsInputs      []ipfsInput
+	globInputs      []globInput
+	inlineInputs    []inlineInput
+	engine          string
+	image           string
+	workdir         string
+	entrypoint      []string
+	wasmModule      string
+	wasmEntrypoint  string
+	wasmParams      []string
+	env             map[string]models.EnvVarValue
+	resultPaths     []*models.ResultPath
+	publisher       string
+	publisherBucket string
+	publisherKey    string
+	publisherRegion string
+	resources       models.ResourcesConfig
+	taskTimeout     time.Duration
+	pollMin         time.Duration
+	pollMax         time.Duration
+	maxPolls        int
+	pollRetries     int
+	submitRetries   int
+	failureRetries  int
+	wait            bool
+	verbose         bool
+	dumpEachPoll    bool
+	noResults       bool
+	follow          bool
+	followTimeout   time.Duration
+	logTail         int
+	logSince        time.Duration
+	dryRun          bool
+	skipPreflight   bool
+	// Inputs from -batch-file, each submitted as its own job
+	batchInputs      []localInput
+	batchConcurrency int
+
+	// Listing
+	limit int
+
+	// Results
+	verifySHA256        string
+	resultName          string
+	allResults          bool
+	downloadConcurrency int
+	resultsWait         time.Duration
+	outputDir           string
+	outputTemplate      *template.Template
+	overwrite           bool
+	keepArchive         bool
+	watch               bool
+	watchInterval       time.Duration
+	cleanupOnError      bool
+	noExtract           bool
+	streamExtract       bool
+	downloadRetries     int
+	downloadClient      *http.Client
+	resume              bool
+	quiet               bool
+	stdout              bool
+	resultFile          string
+	outputFormat        string
+	listFiles           bool
+	extract             extractOptions
+}
+
+// Flag value that can be repeated to collect multiple values
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Parse flags for the default command, which submits a job and waits for its results
+func parseFlags(args []string) (*options, error) {
+	opts := &options{}
+	fs, finishers := newSubmitFlagSet(opts)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := applyConfig(fs); err != nil {
+		return nil, err
+	}
+
+	return opts, finish(finishers)
+}
+
+// Register the default command's flags, returning the checks to run once
+// they are parsed
+func newSubmitFlagSet(opts *options) (*flag.FlagSet, []func() error) {
+	fs := flag.NewFlagSet("bacalhau-file-inputs-poc", flag.ContinueOnError)
+	finishers := []func() error{
+		addClientFlags(fs, opts),
+		addJobFlags(fs, opts),
+		addResultsFlags(fs, opts),
+		// Checks across the job and results flags
+		func() error {
+			if len(opts.batchInputs) > 0 && (opts.stdout || opts.verifySHA256 != "" || opts.watch) {
+				return fmt.Errorf("batch-file can't be combined with -stdout, -verify-sha256 or -watch")
+			}
+			if opts.noResults && (opts.stdout || opts.watch || opts.allResults || opts.noExtract) {
+				return fmt.Errorf("no-results can't be combined with -stdout, -watch, -all-results or -no-extract")
+			}
+			return nil
+		},
+	}
+
+	return fs, finishers
+}
+
+// Parse flags for the get command, which retrieves results of an existing job
+func parseGetFlags(args []string) (*options, string, error) {
+	opts := &options{}
+	fs, finishers := newGetFlagSet(opts)
+
+	jobID, err := parseJobIDArgs(fs, args)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return opts, jobID, finish(finishers)
+}
+
+func newGetFlagSet(opts *options) (*flag.FlagSet, []func() error) {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s get <jobID> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	return fs, []func() error{
+		addClientFlags(fs, opts),
+		addResultsFlags(fs, opts),
+	}
+}
+
+// Parse flags for the describe command, which prints a job as JSON or YAML
+func parseDescribeFlags(args []string) (*options, string, error) {
+	opts := &options{}
+	fs, finishers := newDescribeFlagSet(opts)
+
+	jobID, err := parseJobIDArgs(fs, args)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.outputFormat != "json" && opts.outputFormat != "yaml" {
+		return nil, "", fmt.Errorf("invalid output format %q: expected json or yaml", opts.outputFormat)
+	}
+
+	return opts, jobID, finish(finishers)
+}
+
+func newDescribeFlagSet(opts *options) (*flag.FlagSet, []func() error) {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s describe <jobID> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	finishers := []func() error{
+		addClientFlags(fs, opts),
+	}
+
+	fs.StringVar(&opts.outputFormat, "output", "json", "Output format: json or yaml")
+
+	return fs, finishers
+}
+
+// Parse flags for the stop command, which stops a running job
+func parseStopFlags(args []string) (*options, string, string, error) {
+	opts := &options{}
+	var reason string
+	fs, finishers := newStopFlagSet(opts, &reason)
+
+	jobID, err := parseJobIDArgs(fs, args)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return opts, jobID, reason, finish(finishers)
+}
+
+func newStopFlagSet(opts *options, reason *string) (*flag.FlagSet, []func() error) {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s stop <jobID> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	finishers := []func() error{
+		addClientFlags(fs, opts),
+	}
+
+	fs.StringVar(reason, "reason", "stopped from the command line", "Reason recorded for stopping the job")
+
+	return fs, finishers
+}
+
+// Parse the flags of a command that takes a single job ID. Flags may come
+// before or after the job ID.
+func parseJobIDArgs(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return "", fmt.Errorf("missing job ID")
+	}
+	jobID := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return "", err
+	}
+	if fs.NArg() > 0 {
+		return "", fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := applyConfig(fs); err != nil {
+		return "", err
+	}
+
+	return jobID, nil
+}
+
+// Parse flags for the list command, which shows recently submitted jobs
+func parseListFlags(args []string) (*options, error) {
+	opts := &options{}
+	var labels stringSlice
+	fs, finishers := newListFlagSet(opts, &labels)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if err := applyConfig(fs); err != nil {
+		return nil, err
+	}
+
+	if opts.limit < 1 {
+		return nil, fmt.Errorf("limit must be at least 1")
+	}
+	var err error
+	if opts.labels, err = parseLabels(labels); err != nil {
+		return nil, err
+	}
+
+	return opts, finish(finishers)
+}
+
+func newListFlagSet(opts *options, labels *stringSlice) (*flag.FlagSet, []func() error) {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s list [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	finishers := []func() error{
+		addClientFlags(fs, opts),
+	}
+
+	fs.IntVar(&opts.limit, "limit", 20, "Maximum number of jobs to list")
+	fs.StringVar(&opts.namespace, "namespace", "default", "Namespace to list jobs from")
+	fs.Var(labels, "label", "Only list jobs with the label KEY=VALUE (repeatable)")
+
+	return fs, finishers
+}
+
+// Run the validation for each flag group in order, stopping at the first error
+func finish(finishers []func() error) error {
+	for _, finisher := range finishers {
+		if err := finisher(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Register flags for talking to the Bacalhau API. The returned function
+// validates them once parsed.
+func addClientFlags(fs *flag.FlagSet, opts *options) func() error {
+	// Read by applyConfig once the command line is parsed, which also applies
+	// the environment variables over these flags
+	fs.String("config", "", "YAML file of flag values to use unless given on the command line")
+	fs.StringVar(&opts.apiHost, "api-host", defaultAPIHost, "Bacalhau API address (env: BACALHAU_API_HOST, which takes precedence)")
+	fs.StringVar(&opts.apiToken, "api-token", "", "Bearer token for a secured Bacalhau API (env: BACALHAU_API_TOKEN, which takes precedence)")
+	fs.StringVar(&opts.tlsCAFile, "tls-ca-file", "", "PEM file of CA certificates to trust for the Bacalhau API")
+	fs.BoolVar(&opts.tlsInsecure, "tls-insecure", false, "Skip verifying the Bacalhau API's TLS certificate (insecure)")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Minute, "Overall time limit for the command, 0 for no timeout")
+
+	logLevel := fs.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "text", "Log format: text or json")
+	fs.StringVar(&opts.eventsFile, "events-file", "", "File to append progress events to as JSON lines")
+	fs.StringVar(&opts.metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on at /metrics for the rest of the run, such as :9090")
+
+	return func() error {
+		level, err := parseLogLevel(*logLevel)
+		if err != nil {
+			return err
+		}
+		// Kept adjustable so -quiet can raise it once parsed
+		opts.logLevel = &slog.LevelVar{}
+		opts.logLevel.Set(level)
+		opts.logger, err = newLogger(os.Stderr, opts.logLevel, *logFormat)
+		if err != nil {
+			return err
+		}
+
+		if err := validateAPIHost(opts.apiHost); err != nil {
+			return err
+		}
+		opts.tlsConfig, err = newTLSConfig(opts.tlsCAFile, opts.tlsInsecure)
+		if err != nil {
+			return err
+		}
+
+		if opts.timeout < 0 {
+			return fmt.Errorf("timeout must not be negative")
+		}
+
+		// Opened by startReporting once every flag is parsed, so a later
+		// parse error doesn't leave them behind
+		if opts.metricsAddr != "" {
+			if _, _, err := net.SplitHostPort(opts.metricsAddr); err != nil {
+				return fmt.Errorf("invalid metrics-addr %q: %s", opts.metricsAddr, err.Error())
+			}
+		}
+
+		return nil
+	}
+}
+
+// Register flags describing the job to submit and how to wait for it. The
+// returned function validates them once parsed.
+func addJobFlags(fs *flag.FlagSet, opts *options) func() error {
+	jobFile := fs.String("job-file", "", "JSON or YAML job spec to submit instead of building one from flags")
+
+	fs.StringVar(&opts.name, "name", "copy-file-contents", "Name of the job")
+	fs.StringVar(&opts.taskName, "task-name", "", "Name of the job's task (default the job name)")
+	fs.StringVar(&opts.namespace, "namespace", "default", "Namespace to submit the job to")
+
+	var labels stringSlice
+	fs.Var(&labels, "label", "Label to attach to the job as KEY=VALUE (repeatable)")
+
+	var constraints stringSlice
+	fs.Var(&constraints, "constraint", "Node label selector such as gpu=true, zone in (a,b) or !spot (repeatable)")
+
+	fs.StringVar(&opts.jobType, "type", models.JobTypeBatch, "Job type: batch, ops, service or daemon")
+	fs.IntVar(&opts.count, "count", 1, "Number of executions of the job to run")
+	fs.IntVar(&opts.priority, "priority", 50, "Scheduling priority of the job, from 0 to 100")
+
+	var inputs stringSlice
+	fs.Var(&inputs, "input", "Host path to mount as /host/path:/container/path[:rw] (repeatable)")
+
+	inputRW := fs.Bool("input-rw", false, "Mount the default inputs directory read-write instead of read-only")
+
+	var s3Inputs stringSlice
+	fs.Var(&s3Inputs, "input-s3", "S3 object or prefix to download as bucket/key[:/container/path] (repeatable)")
+	s3Region := fs.String("s3-region", "", "Region of the -input-s3 buckets")
+	s3Endpoint := fs.String("s3-endpoint", "", "Endpoint for S3-compatible storage used by -input-s3")
+
+	var urlInputs stringSlice
+	fs.Var(&urlInputs, "input-url", "HTTP(S) file to download as URL:/container/path (repeatable)")
+
+	var ipfsInputs stringSlice
+	fs.Var(&ipfsInputs, "input-ipfs", "IPFS content to fetch as CID[:/container/path] (repeatable)")
+
+	var inlineInputs stringSlice
+	fs.Var(&inlineInputs, "input-inline", "Content to mount as a file, as /container/path=content (repeatable)")
+
+	var globInputs stringSlice
+	fs.Var(&globInputs, "input-glob", "Host files to mount together as pattern[:/container/dir], default dir /tmp (repeatable)")
+
+	fs.StringVar(&opts.engine, "engine", "docker", "Engine to run the task with: docker or wasm")
+
+	fs.StringVar(&opts.image, "image", defaultImage, "Docker image to run")
+	fs.StringVar(&opts.workdir, "workdir", "", "Absolute container path to run the Docker entrypoint in (default the image's)")
+
+	var entrypoint stringSlice
+	fs.Var(&entrypoint, "entrypoint", "Entrypoint argument for the container, in order (repeatable)")
+
+	fs.StringVar(&opts.wasmModule, "wasm-module", "", "Container path of the input holding the WASM entry module")
+	fs.StringVar(&opts.wasmEntrypoint, "wasm-entrypoint", "", "Function in the WASM entry module to call (default _start)")
+
+	var wasmParams stringSlice
+	fs.Var(&wasmParams, "wasm-param", "Argument passed to the WASM program, in order (repeatable)")
+
+	var env stringSlice
+	fs.Var(&env, "env", "Environment variable for the task as KEY=VALUE (repeatable)")
+
+	var resultPaths stringSlice
+	fs.Var(&resultPaths, "result-path", "Container path to publish as a named result, as name:/container/path (repeatable, default outputs:/outputs)")
+
+	fs.StringVar(&opts.publisher, "publisher", "local", "Where to publish results: local, s3 or ipfs")
+	fs.StringVar(&opts.publisherBucket, "publisher-bucket", "", "Bucket to publish results to with -publisher s3")
+	fs.StringVar(&opts.publisherKey, "publisher-key", "", "Object key for results with -publisher s3 (default "+defaultPublisherKey+")")
+	fs.StringVar(&opts.publisherRegion, "publisher-region", "", "Region of the -publisher-bucket")
+
+	fs.StringVar(&opts.resources.CPU, "cpu", "0.5", "CPU to request for the task, e.g. 0.5 or 500m")
+	fs.StringVar(&opts.resources.Memory, "memory", "100m", "Memory to request for the task, e.g. 100m or 2Gb")
+	fs.StringVar(&opts.resources.GPU, "gpu", "0", "Number of GPUs to request for the task")
+	fs.DurationVar(&opts.taskTimeout, "task-timeout", 0, "How long the task may run on the cluster before it's stopped, rounded up to whole seconds (default no limit)")
+
+	fs.DurationVar(&opts.pollMin, "poll-min", 1*time.Second, "Initial interval between job status checks")
+	fs.DurationVar(&opts.pollMax, "poll-max", 30*time.Second, "Maximum interval between job status checks")
+	fs.IntVar(&opts.maxPolls, "max-polls", 0, "Give up after this many job status checks without a terminal state; 0 means unlimited")
+	fs.IntVar(&opts.pollRetries, "poll-retries", 5, "Times in a row to retry a job status check while the orchestrator is unavailable")
+
+	fs.IntVar(&opts.submitRetries, "submit-retries", 3, "Times to retry submitting the job while the orchestrator is unavailable")
+	fs.IntVar(&opts.failureRetries, "retries-on-failure", 0, "Times to resubmit the same job spec when the job fails")
+
+	fs.BoolVar(&opts.wait, "wait", true, "Wait for the job to finish and retrieve its outputs; when false, print the job ID and exit")
+	fs.BoolVar(&opts.noResults, "no-results", false, "Wait for the job to finish but don't retrieve its outputs, only report its state")
+
+	fs.BoolVar(&opts.verbose, "verbose", false, "Log each execution's node, state and failure message while polling")
+	fs.BoolVar(&opts.dumpEachPoll, "dump-each-poll", false, "Log the full job spec, state and executions on every status check")
+
+	fs.BoolVar(&opts.follow, "follow", false, "Stream execution logs while the job runs")
+	fs.DurationVar(&opts.followTimeout, "follow-timeout", 5*time.Second, "How long to keep tailing logs after the job finishes, to catch trailing output")
+	fs.IntVar(&opts.logTail, "log-tail", 0, "With -follow, only show the last N lines of output from before streaming started, or all of it when 0")
+	fs.DurationVar(&opts.logSince, "log-since", 0, "With -follow, only show output from within this long ago. The logs API can't filter by time, so any value skips the history and follows new output only")
+
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Print the job spec as JSON and exit without submitting it")
+	fs.BoolVar(&opts.skipPreflight, "skip-preflight", false, "Submit without first checking that the Bacalhau API is reachable")
+
+	batchFile := fs.String("batch-file", "", "File listing one input per line as /host/path[:/container/path], to submit a job for each")
+	fs.IntVar(&opts.batchConcurrency, "batch-concurrency", 4, "Maximum number of -batch-file jobs to run at once")
+
+	return func() error {
+		if *batchFile != "" {
+			if *jobFile != "" || opts.follow || opts.dryRun || !opts.wait {
+				return fmt.Errorf("batch-file can't be combined with -job-file, -follow, -dry-run or -wait=false")
+			}
+			if opts.batchConcurrency < 1 {
+				return fmt.Errorf("batch-concurrency must be at least 1")
+			}
+			var err error
+			if opts.batchInputs, err = parseBatchFile(*batchFile); err != nil {
+				return err
+			}
+		}
+
+		if strings.TrimSpace(opts.name) == "" {
+			return fmt.Errorf("name must not be empty")
+		}
+		if opts.taskName == "" {
+			opts.taskName = opts.name
+		} else if strings.TrimSpace(opts.taskName) == "" {
+			return fmt.Errorf("task-name must not be empty")
+		}
+		if strings.TrimSpace(opts.namespace) == "" {
+			return fmt.Errorf("namespace must not be empty")
+		}
+
+		switch opts.jobType {
+		case models.JobTypeBatch, models.JobTypeOps, models.JobTypeService, models.JobTypeDaemon:
+		default:
+			return fmt.Errorf("unsupported job type %q, expected batch, ops, service or daemon", opts.jobType)
+		}
+
+		if opts.count < 1 {
+			return fmt.Errorf("count must be at least 1")
+		}
+		// These types run once on every matching node instead
+		if opts.count > 1 && (opts.jobType == models.JobTypeOps || opts.jobType == models.JobTypeDaemon) {
+			return fmt.Errorf("count must be 1 for %s jobs", opts.jobType)
+		}
+		if opts.priority < 0 || opts.priority > 100 {
+			return fmt.Errorf("priority must be between 0 and 100")
+		}
+		if isFlagSet(fs, "task-timeout") && opts.taskTimeout <= 0 {
+			return fmt.Errorf("task-timeout must be positive")
+		}
+		// Long-running tasks are meant to run until stopped
+		if opts.taskTimeout > 0 && isLongRunning(opts.jobType) {
+			return fmt.Errorf("task-timeout can't be used with %s jobs", opts.jobType)
+		}
+
+		if *jobFile != "" {
+			return loadJobFileFlags(fs, opts, *jobFile)
+		}
+
+		var err error
+		if opts.labels, err = parseLabels(labels); err != nil {
+			return err
+		}
+		if opts.constraints, err = parseConstraints(constraints); err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(opts.image) == "" {
+			return fmt.Errorf("image must not be empty")
+		}
+
+		if err := validateResources(opts.resources); err != nil {
+			return err
+		}
+
+		if opts.submitRetries < 0 {
+			return fmt.Errorf("submit-retries must not be negative")
+		}
+		if opts.failureRetries < 0 {
+			return fmt.Errorf("retries-on-failure must not be negative")
+		}
+
+		if opts.pollMin <= 0 {
+			return fmt.Errorf("poll-min must be positive")
+		}
+		if opts.pollMax < opts.pollMin {
+			return fmt.Errorf("poll-max must be greater than or equal to poll-min")
+		}
+		if opts.maxPolls < 0 {
+			return fmt.Errorf("max-polls must not be negative")
+		}
+		if opts.pollRetries < 0 {
+			return fmt.Errorf("poll-retries must not be negative")
+		}
+		if opts.followTimeout < 0 {
+			return fmt.Errorf("follow-timeout must not be negative")
+		}
+		if opts.logTail < 0 {
+			return fmt.Errorf("log-tail must not be negative")
+		}
+		if opts.logSince < 0 {
+			return fmt.Errorf("log-since must not be negative")
+		}
+		if opts.logSince > 0 && opts.logTail > 0 {
+			return fmt.Errorf("log-since can't be combined with -log-tail")
+		}
+
+		opts.entrypoint = entrypoint
+		if len(opts.entrypoint) == 0 {
+			opts.entrypoint = defaultEntrypoint
+		}
+		opts.wasmParams = wasmParams
+
+		if len(env) > 0 {
+			opts.env = make(map[string]models.EnvVarValue, len(env))
+			for _, spec := range env {
+				key, value, err := parseKeyValue(spec)
+				if err != nil {
+					return fmt.Errorf("invalid env %s", err.Error())
+				}
+				opts.env[key] = models.EnvVarValue(value)
+			}
+			if err := models.ValidateEnvVars(opts.env); err != nil {
+				return err
+			}
+		}
+
+		for _, spec := range inputs {
+			input, err := parseLocalInput(spec)
+			if err != nil {
+				return err
+			}
+			opts.inputs = append(opts.inputs, input)
+		}
+		for _, spec := range globInputs {
+			input, err := parseGlobInput(spec)
+			if err != nil {
+				return err
+			}
+			opts.globInputs = append(opts.globInputs, input)
+		}
+		for _, spec := range inlineInputs {
+			input, err := parseInlineInput(spec)
+			if err != nil {
+				return err
+			}
+			opts.inlineInputs = append(opts.inlineInputs, input)
+		}
+		if *inputRW && (len(opts.inputs) > 0 || len(opts.globInputs) > 0) {
+			return fmt.Errorf("input-rw applies to the default inputs directory, use the :rw suffix with -input")
+		}
+		// Each batch job already has its input
+		if len(opts.inputs) == 0 && len(opts.globInputs) == 0 && len(opts.batchInputs) == 0 {
+			inputsPath, err := getInputsPath()
+			if err != nil {
+				return err
+			}
+			if err := checkInputPath(inputsPath); err != nil {
+				return fmt.Errorf("invalid default input: %s", err.Error())
+			}
+			opts.inputs = append(opts.inputs, localInput{
+				hostPath:  inputsPath,
+				target:    "/tmp",
+				readWrite: *inputRW,
+			})
+		}
+
+		for _, spec := range s3Inputs {
+			input, err := parseS3Input(spec, *s3Region, *s3Endpoint)
+			if err != nil {
+				return err
+			}
+			opts.s3Inputs = append(opts.s3Inputs, input)
+		}
+
+		for _, spec := range urlInputs {
+			input, err := parseURLInput(spec)
+			if err != nil {
+				return err
+			}
+			opts.urlInputs = append(opts.urlInputs, input)
+		}
+
+		for _, spec := range ipfsInputs {
+			input, err := parseIPFSInput(spec)
+			if err != nil {
+				return err
+			}
+			opts.ipfsInputs = append(opts.ipfsInputs, input)
+		}
+
+		for _, spec := range resultPaths {
+			resultPath, err := parseResultPath(spec)
+			if err != nil {
+				return err
+			}
+			for _, other := range opts.resultPaths {
+				if other.Name == resultPath.Name {
+					return fmt.Errorf("invalid result path %q: duplicate name %s", spec, resultPath.Name)
+				}
+			}
+			opts.resultPaths = append(opts.resultPaths, resultPath)
+		}
+
+		if err := validatePublisher(opts); err != nil {
+			return err
+		}
+
+		return validateEngine(opts)
+	}
+}
+
+// Register flags controlling how results are downloaded and extracted. The
+// returned function validates them once parsed.
+func addResultsFlags(fs *flag.FlagSet, opts *options) func() error {
+	fs.StringVar(&opts.verifySHA256, "verify-sha256", "", "Expected SHA-256 of the results tarball, as hex")
+
+	fs.StringVar(&opts.resultName, "result-name", "", "Result archive to download, by file name or execution ID (default first result with a URL)")
+	fs.BoolVar(&opts.allResults, "all-results", false, "Download every result of the job, each into its own subdirectory")
+	fs.IntVar(&opts.downloadConcurrency, "download-concurrency", 4, "Maximum number of results to download at once with -all-results")
+
+	fs.DurationVar(&opts.resultsWait, "results-wait", 10*time.Second, "How long to wait for results to be published after the job completes")
+
+	fs.StringVar(&opts.outputDir, "output-dir", "./outputs", "Directory to download and extract results into")
+	outputTemplate := fs.String("output-template", "", "Template for each job's output path instead of <output-dir>/<jobID>, e.g. results/{{.Date}}/{{.JobID}}")
+	fs.BoolVar(&opts.overwrite, "overwrite", false, "Replace existing outputs and files for the job instead of failing")
+
+	fs.BoolVar(&opts.keepArchive, "keep-archive", false, "Keep the downloaded results tarball after extracting it")
+	fs.BoolVar(&opts.cleanupOnError, "cleanup-on-error", false, "Remove the job's output directory when retrieving results fails partway")
+	fs.BoolVar(&opts.noExtract, "no-extract", false, "Download and verify the results tarball without extracting it")
+	fs.BoolVar(&opts.streamExtract, "stream-extract", false, "Extract results as they download, without writing the archive to disk")
+
+	fs.BoolVar(&opts.watch, "watch", false, "Keep downloading new results of a running job as it publishes them, until interrupted")
+	fs.DurationVar(&opts.watchInterval, "watch-interval", 10*time.Second, "How often to check for new results with -watch")
+
+	fs.IntVar(&opts.downloadRetries, "download-retries", 3, "Times to retry a failed results download")
+	connectTimeout := fs.Duration("download-connect-timeout", 30*time.Second, "How long to wait to connect to the results server, 0 for no limit")
+	headerTimeout := fs.Duration("download-header-timeout", time.Minute, "How long to wait for the results server to start responding, 0 for no limit")
+
+	fs.BoolVar(&opts.resume, "resume", false, "Resume a partially downloaded results tarball instead of starting over")
+
+	fs.BoolVar(&opts.quiet, "quiet", false, "Only log errors, and hide download progress")
+
+	fs.BoolVar(&opts.stdout, "stdout", false, "Write the -result-file entry of the results archive to stdout instead of extracting to disk")
+	fs.StringVar(&opts.resultFile, "result-file", "", "Path of the file inside the results archive to write with -stdout")
+
+	fs.BoolVar(&opts.listFiles, "list-files", false, "Log each extracted file and its size")
+
+	fs.StringVar(&opts.outputFormat, "output", "text", "Output format: text, or json for a summary of the run on stdout")
+
+	fs.Int64Var(&opts.extract.maxBytes, "max-extract-bytes", 4<<30, "Maximum total bytes to extract from the results archive")
+	fs.IntVar(&opts.extract.maxEntries, "max-extract-entries", 100000, "Maximum number of entries to extract from the results archive")
+	archiveFormat := fs.String("archive-format", "auto", "Format of the results archive: auto to detect it, targz, tar or zip")
+	fs.IntVar(&opts.extract.workers, "extract-workers", 4, "Number of files to write at once while extracting a tar archive, 1 to write them in order")
+	var include, exclude stringSlice
+	fs.Var(&include, "extract-include", "Only extract entries matching this glob, such as *.log or logs/* (repeatable)")
+	fs.Var(&exclude, "extract-exclude", "Don't extract entries matching this glob, applied after -extract-include (repeatable)")
+
+	return func() error {
+		if opts.downloadRetries < 0 {
+			return fmt.Errorf("download-retries must not be negative")
+		}
+		if opts.downloadConcurrency < 1 {
+			return fmt.Errorf("download-concurrency must be at least 1")
+		}
+		if opts.allResults && (opts.resultName != "" || opts.stdout || opts.verifySHA256 != "") {
+			return fmt.Errorf("all-results can't be combined with -result-name, -stdout or -verify-sha256")
+		}
+		if opts.noExtract && (opts.allResults || opts.stdout || opts.listFiles) {
+			return fmt.Errorf("no-extract can't be combined with -all-results, -stdout or -list-files")
+		}
+		// Without an archive on disk there's nothing to hash, keep or resume
+		if opts.streamExtract && (opts.verifySHA256 != "" || opts.noExtract || opts.keepArchive || opts.resume || opts.stdout) {
+			return fmt.Errorf("stream-extract can't be combined with -verify-sha256, -no-extract, -keep-archive, -resume or -stdout")
+		}
+		// Watching downloads every result as it appears
+		if opts.watch && (opts.allResults || opts.resultName != "" || opts.stdout || opts.noExtract || opts.verifySHA256 != "") {
+			return fmt.Errorf("watch can't be combined with -all-results, -result-name, -stdout, -no-extract or -verify-sha256")
+		}
+		if opts.watchInterval <= 0 {
+			return fmt.Errorf("watch-interval must be positive")
+		}
+		if *outputTemplate != "" {
+			tmpl, err := parseOutputTemplate(*outputTemplate)
+			if err != nil {
+				return err
+			}
+			opts.outputTemplate = tmpl
+		}
+		if *connectTimeout < 0 || *headerTimeout < 0 {
+			return fmt.Errorf("download-connect-timeout and -download-header-timeout must not be negative")
+		}
+		opts.downloadClient = newDownloadClient(*connectTimeout, *headerTimeout)
+		if opts.resultsWait < 0 {
+			return fmt.Errorf("results-wait must not be negative")
+		}
+
+		if opts.outputFormat != "text" && opts.outputFormat != "json" {
+			return fmt.Errorf("invalid output format %q: expected text or json", opts.outputFormat)
+		}
+
+		if opts.stdout && opts.resultFile == "" {
+			return fmt.Errorf("result-file is required with -stdout")
+		}
+		if !opts.stdout && opts.resultFile != "" {
+			return fmt.Errorf("result-file only applies to -stdout")
+		}
+		// The entry is written out as it arrives, before the whole archive
+		// could be hashed
+		if opts.stdout && opts.verifySHA256 != "" {
+			return fmt.Errorf("stdout can't be combined with -verify-sha256")
+		}
+		if opts.stdout && opts.outputFormat == "json" {
+			return fmt.Errorf("stdout and -output json both write to stdout, pick one")
+		}
+
+		opts.extract.overwrite = opts.overwrite
+
+		if opts.quiet {
+			opts.logLevel.Set(slog.LevelError)
+		}
+
+		if opts.extract.maxBytes <= 0 {
+			return fmt.Errorf("max-extract-bytes must be positive")
+		}
+		if opts.extract.maxEntries <= 0 {
+			return fmt.Errorf("max-extract-entries must be positive")
+		}
+		if opts.extract.workers < 1 {
+			return fmt.Errorf("extract-workers must be at least 1")
+		}
+		var err error
+		if opts.extract.format, err = parseArchiveFormat(*archiveFormat); err != nil {
+			return err
+		}
+		if err := checkExtractPatterns(append(include, exclude...)); err != nil {
+			return err
+		}
+		opts.extract.include, opts.extract.exclude = include, exclude
+
+		opts.verifySHA256 = strings.ToLower(opts.verifySHA256)
+		if opts.verifySHA256 != "" {
+			if digest, err := hex.DecodeString(opts.verifySHA256); err != nil || len(digest) != sha256.Size {
+				return fmt.Errorf("verify-sha256 must be a %d character hex digest", sha256.Size*2)
+			}
+		}
+
+		return nil
+	}
+}
+
+func validateAPIHost(apiHost string) error {
+	u, err := url.Parse(apiHost)
+	if err != nil {
+		return fmt.Errorf("invalid API host %q: %s", apiHost, err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid API host %q: must include a scheme and host, e.g. %s", apiHost, defaultAPIHost)
+	}
+
+	return nil
+}
+
+// Check resource quantities parse the way Bacalhau will parse them
+func validateResources(resources models.ResourcesConfig) error {
+	parsed, err := resources.Copy().ToResources()
+	if err != nil {
+		return fmt.Errorf("invalid resources: %s", err.Error())
+	}
+	if err := parsed.Validate(); err != nil {
+		return fmt.Errorf("invalid resources: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Split a KEY=VALUE flag value, requiring a non-empty key
+func parseKeyValue(spec string) (string, string, error) {
+	key, value, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return "", "", fmt.Errorf("%q: expected KEY=VALUE", spec)
+	}
+
+	return key, value, nil
+}
+
+// Load -job-file, overlaying only the -name, -task-name and -count flags given
+// on the command line. The other job flags are ignored.
+func loadJobFileFlags(fs *flag.FlagSet, opts *options, path string) error {
+	job, err := loadJobFile(path)
+	if err != nil {
+		return err
+	}
+	opts.jobFile = job
+
+	if isFlagSet(fs, "name") {
+		opts.jobOverrides = append(opts.jobOverrides, withName(opts.name))
+	}
+	if isFlagSet(fs, "count") {
+		opts.jobOverrides = append(opts.jobOverrides, withCount(opts.count))
+	}
+	if isFlagSet(fs, "task-name") {
+		opts.jobOverrides = append(opts.jobOverrides, withTaskName(opts.taskName))
+	}
+	if isFlagSet(fs, "task-timeout") {
+		if isLongRunning(job.Type) {
+			return fmt.Errorf("task-timeout can't be used with %s jobs", job.Type)
+		}
+		opts.jobOverrides = append(opts.jobOverrides, withTaskTimeout(opts.taskTimeout))
+	}
+
+	// Validate a normalized copy, as the orchestrator would, leaving the
+	// submitted spec as written
+	spec := getJob(opts)
+	normalized := spec.Copy()
+	normalized.Normalize()
+	if err := normalized.ValidateSubmission(); err != nil {
+		return fmt.Errorf("invalid job file %s: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+// Report whether a flag was given on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// Parse a -result-path value of the form name:/container/path
+func parseResultPath(spec string) (*models.ResultPath, error) {
+	name, containerPath, ok := strings.Cut(spec, ":")
+	if !ok || name == "" || !strings.HasPrefix(containerPath, "/") {
+		return nil, fmt.Errorf("invalid result path %q: expected name:/container/path", spec)
+	}
+	if strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid result path %q: name must not contain path separators", spec)
+	}
+
+	return &models.ResultPath{Name: name, Path: containerPath}, nil
+}
+
+// Parse -constraint values as Kubernetes label selectors, which validates
+// their operators and values, into the job's placement constraints
+func parseConstraints(specs []string) ([]*models.LabelSelectorRequirement, error) {
+	var constraints []*models.LabelSelectorRequirement
+	for _, spec := range specs {
+		requirements, err := k8slabels.ParseToRequirements(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint %q: %s", spec, err.Error())
+		}
+		if len(requirements) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q: expected a label selector such as key=value", spec)
+		}
+		for _, requirement := range models.ToLabelSelectorRequirements(requirements...) {
+			constraints = append(constraints, &requirement)
+		}
+	}
+
+	return constraints, nil
+}
+
+// Parse repeated KEY=VALUE labels, rejecting duplicate keys
+func parseLabels(specs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		key, value, err := parseLabel(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("invalid label %q: duplicate key %s", spec, key)
+		}
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
+// Parse a -label value, checking it against the label syntax Bacalhau uses
+// when selecting jobs by label
+func parseLabel(spec string) (string, string, error) {
+	key, value, err := parseKeyValue(spec)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid label %s", err.Error())
+	}
+
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid label %q: key %s", spec, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid label %q: value %s", spec, strings.Join(errs, "; "))
+	}
+
+	return key, value, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Host files matching a pattern, mounted together under one container directory
+type globInput struct {
+	pattern string
+	target  string
+	matches []string
+}
+
+// Parse an -input-glob value of the form pattern[:/container/dir], expanding
+// the pattern right away so a pattern matching nothing fails before submitting
+func parseGlobInput(spec string) (globInput, error) {
+	input := globInput{target: "/tmp"}
+
+	pattern, target, hasTarget := strings.Cut(spec, ":")
+	if hasTarget {
+		target, err := cleanTarget(target)
+		if err != nil {
+			return input, fmt.Errorf("invalid input glob %q: %s", spec, err.Error())
+		}
+		input.target = target
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return input, fmt.Errorf("invalid input glob %q: %s", spec, err.Error())
+	}
+	if len(matches) == 0 {
+		return input, fmt.Errorf("input glob %q matches no files", pattern)
+	}
+
+	names := map[string]string{}
+	for i, match := range matches {
+		absPath, err := filepath.Abs(match)
+		if err != nil {
+			return input, fmt.Errorf("invalid input glob %q: %s", spec, err.Error())
+		}
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return input, fmt.Errorf("invalid input glob %q: %s", spec, err.Error())
+		}
+		if !info.Mode().IsRegular() {
+			return input, fmt.Errorf("input glob %q matches %s, which is not a regular file", pattern, match)
+		}
+
+		// Matches are staged side by side, so their names must be unique
+		name := filepath.Base(absPath)
+		if other, ok := names[name]; ok {
+			return input, fmt.Errorf("input glob %q matches both %s and %s, which share a file name", pattern, other, match)
+		}
+		names[name] = match
+		matches[i] = absPath
+	}
+
+	input.pattern = pattern
+	input.matches = matches
+
+	return input, nil
+}
@@ -2,12 +2,17 @@ module bacalhau-file-inputs-poc
 
 go 1.23.3
 
+require (
+	github.com/bacalhau-project/bacalhau v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
+	k8s.io/apimachinery v0.29.0
+)
+
 require (
 	github.com/BTBurke/k8sresource v1.2.0 // indirect
 	github.com/Masterminds/semver v1.5.0 // indirect
 	github.com/MicahParks/jwkset v0.8.0 // indirect
 	github.com/MicahParks/keyfunc/v3 v3.3.10 // indirect
-	github.com/bacalhau-project/bacalhau v1.7.0 // indirect
 	github.com/c2h5oh/datasize v0.0.0-20220606134207-859f65c6625b // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
@@ -49,8 +54,6 @@ require (
 	golang.org/x/text v0.22.0 // indirect
 	golang.org/x/time v0.10.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-	k8s.io/apimachinery v0.29.0 // indirect
 	k8s.io/klog/v2 v2.110.1 // indirect
 	k8s.io/utils v0.0.0-20230726121419-3b25d923346b // indirect
 )
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// A host file or directory mounted into the task container
+type localInput struct {
+	hostPath  string
+	target    string
+	readWrite bool
+}
+
+// Parse an -input value of the form /host/path:/container/path[:rw]
+func parseLocalInput(spec string) (localInput, error) {
+	input := localInput{}
+
+	rest := spec
+	if strings.HasSuffix(rest, ":rw") {
+		input.readWrite = true
+		rest = strings.TrimSuffix(rest, ":rw")
+	}
+
+	hostPath, target, ok := strings.Cut(rest, ":")
+	if !ok || hostPath == "" {
+		return input, fmt.Errorf("invalid input %q: expected /host/path:/container/path[:rw]", spec)
+	}
+	target, err := cleanTarget(target)
+	if err != nil {
+		return input, fmt.Errorf("invalid input %q: %s", spec, err.Error())
+	}
+
+	absPath, err := filepath.Abs(hostPath)
+	if err != nil {
+		return input, fmt.Errorf("invalid input %q: %s", spec, err.Error())
+	}
+	if err := checkInputPath(absPath); err != nil {
+		return input, fmt.Errorf("invalid input %q: %s", spec, err.Error())
+	}
+
+	input.hostPath = absPath
+	input.target = target
+
+	return input, nil
+}
+
+// Require a container path to be absolute, returning it cleaned. A relative
+// path would otherwise be mounted somewhere unexpected, or not at all.
+func cleanTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("container path must not be empty")
+	}
+	if !path.IsAbs(target) {
+		return "", fmt.Errorf("container path %q must be absolute", target)
+	}
+
+	return path.Clean(target), nil
+}
+
+// Make sure a host input is a readable file or directory before submitting,
+// rather than leaving the executor to fail on it
+func checkInputPath(absPath string) error {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("input path %s is not accessible: %s", absPath, err.Error())
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return fmt.Errorf("input path %s is not a file or directory", absPath)
+	}
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return fmt.Errorf("input path %s is not readable: %s", absPath, err.Error())
+	}
+	f.Close()
+
+	return nil
+}
+
+// Literal content written to a file and mounted into the task container
+type inlineInput struct {
+	target  string
+	content string
+}
+
+// Parse an -input-inline value of the form /container/path=content
+func parseInlineInput(spec string) (inlineInput, error) {
+	target, content, ok := strings.Cut(spec, "=")
+	if !ok || strings.HasSuffix(target, "/") {
+		return inlineInput{}, fmt.Errorf("invalid inline input %q: expected /container/path=content", spec)
+	}
+	target, err := cleanTarget(target)
+	if err != nil {
+		return inlineInput{}, fmt.Errorf("invalid inline input %q: %s", spec, err.Error())
+	}
+
+	return inlineInput{target: target, content: content}, nil
+}
+
+// An S3 object or prefix downloaded into the task container
+type s3Input struct {
+	bucket   string
+	key      string
+	region   string
+	endpoint string
+	target   string
+}
+
+// Container path used for remote inputs that don't specify one
+const defaultRemoteTarget = "/inputs"
+
+// Parse an -input-s3 value of the form bucket/key[:/container/path]
+func parseS3Input(spec, region, endpoint string) (s3Input, error) {
+	input := s3Input{
+		region:   region,
+		endpoint: endpoint,
+		target:   defaultRemoteTarget,
+	}
+
+	location, target, hasTarget := strings.Cut(strings.TrimPrefix(spec, "s3://"), ":")
+	if hasTarget {
+		target, err := cleanTarget(target)
+		if err != nil {
+			return input, fmt.Errorf("invalid S3 input %q: %s", spec, err.Error())
+		}
+		input.target = target
+	}
+
+	bucket, key, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return input, fmt.Errorf("invalid S3 input %q: expected bucket/key[:/container/path]", spec)
+	}
+
+	input.bucket = bucket
+	input.key = key
+
+	return input, nil
+}
+
+// A remote file downloaded over HTTP(S) into the task container
+type urlInput struct {
+	url    string
+	target string
+}
+
+// Parse an -input-url value of the form URL:/container/path
+func parseURLInput(spec string) (urlInput, error) {
+	input := urlInput{}
+
+	// The URL itself contains colons, so split on the last one that starts an absolute path
+	i := strings.LastIndex(spec, ":/")
+	if i <= 0 {
+		return input, fmt.Errorf("invalid URL input %q: expected URL:/container/path", spec)
+	}
+	rawURL, target := spec[:i], spec[i+1:]
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return input, fmt.Errorf("invalid URL input %q: expected an http or https URL followed by :/container/path", spec)
+	}
+
+	target, err = cleanTarget(target)
+	if err != nil {
+		return input, fmt.Errorf("invalid URL input %q: %s", spec, err.Error())
+	}
+
+	input.url = rawURL
+	input.target = target
+
+	return input, nil
+}
+
+// Content on IPFS, fetched by CID into the task container
+type ipfsInput struct {
+	cid    string
+	target string
+}
+
+// Parse an -input-ipfs value of the form CID[:/container/path]
+func parseIPFSInput(spec string) (ipfsInput, error) {
+	input := ipfsInput{target: defaultRemoteTarget}
+
+	cid, target, hasTarget := strings.Cut(strings.TrimPrefix(spec, "ipfs://"), ":")
+	if hasTarget {
+		target, err := cleanTarget(target)
+		if err != nil {
+			return input, fmt.Errorf("invalid IPFS input %q: %s", spec, err.Error())
+		}
+		input.target = target
+	}
+	if err := checkCID(cid); err != nil {
+		return input, fmt.Errorf("invalid IPFS input %q: %s", spec, err.Error())
+	}
+
+	input.cid = cid
+
+	return input, nil
+}
+
+// Base58 alphabet used by CIDv0, which leaves out 0, I, O and l
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Catch obviously malformed CIDs before submitting. Only the common encodings
+// are accepted: CIDv0 (Qm..., base58) and CIDv1 in base32 (b..., such as bafy...).
+func checkCID(cid string) error {
+	switch {
+	case strings.HasPrefix(cid, "Qm"):
+		if len(cid) != 46 || strings.Trim(cid, base58Alphabet) != "" {
+			return fmt.Errorf("CIDv0 %q must be 46 base58 characters", cid)
+		}
+	case strings.HasPrefix(cid, "b"):
+		if len(cid) < 10 || strings.Trim(cid[1:], "abcdefghijklmnopqrstuvwxyz234567") != "" {
+			return fmt.Errorf("CIDv1 %q must be lowercase base32 of at least 10 characters", cid)
+		}
+	default:
+		return fmt.Errorf("expected a CID starting with Qm or b, got %q", cid)
+	}
+
+	return nil
+}
+
+// Build input sources from -input* flags
+func getInputSources(opts *options) []*models.InputSource {
+	sources := make([]*models.InputSource, 0, len(opts.inputs)+len(opts.s3Inputs)+len(opts.urlInputs)+len(opts.ipfsInputs))
+	for _, input := range opts.inputs {
+		sources = append(sources, &models.InputSource{
+			Source: &models.SpecConfig{
+				Type: "localDirectory",
+				Params: map[string]any{
+					"SourcePath": input.hostPath,
+					"ReadWrite":  input.readWrite,
+				},
+			},
+			Target: input.target,
+		})
+	}
+
+	for _, input := range opts.s3Inputs {
+		params := map[string]any{
+			"Bucket": input.bucket,
+			"Key":    input.key,
+		}
+		if input.region != "" {
+			params["Region"] = input.region
+		}
+		if input.endpoint != "" {
+			params["Endpoint"] = input.endpoint
+		}
+
+		sources = append(sources, &models.InputSource{
+			Source: &models.SpecConfig{
+				Type:   "s3",
+				Params: params,
+			},
+			Target: input.target,
+		})
+	}
+
+	for _, input := range opts.urlInputs {
+		sources = append(sources, &models.InputSource{
+			Source: &models.SpecConfig{
+				Type: "urlDownload",
+				Params: map[string]any{
+					"URL": input.url,
+				},
+			},
+			Target: input.target,
+		})
+	}
+
+	for _, input := range opts.ipfsInputs {
+		sources = append(sources, &models.InputSource{
+			Source: &models.SpecConfig{
+				Type: "ipfs",
+				Params: map[string]any{
+					"CID": input.cid,
+				},
+			},
+			Target: input.target,
+		})
+	}
+
+	return sources
+}
+
+// Get the container paths of all inputs. Glob inputs aren't staged yet, so
+// each match is given the path it will be mounted at, in the glob's directory.
+func inputTargets(opts *options) []string {
+	var targets []string
+	for _, input := range opts.inputs {
+		targets = append(targets, input.target)
+	}
+	for _, input := range opts.globInputs {
+		for _, match := range input.matches {
+			targets = append(targets, path.Join(input.target, filepath.Base(match)))
+		}
+	}
+	for _, input := range opts.s3Inputs {
+		targets = append(targets, input.target)
+	}
+	for _, input := range opts.urlInputs {
+		targets = append(targets, input.target)
+	}
+	for _, input := range opts.ipfsInputs {
+		targets = append(targets, input.target)
+	}
+	for _, input := range opts.inlineInputs {
+		targets = append(targets, input.target)
+	}
+
+	return targets
+}
+
+// Get absolute path for inputs
+func getInputsPath() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current working directory: %s", err.Error())
+	}
+
+	return filepath.Join(cwd, "inputs"), nil
+}
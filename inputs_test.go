@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestCleanTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    string
+		wantErr string
+	}{
+		{target: "/inputs", want: "/inputs"},
+		{target: "/inputs/../data//file.txt", want: "/data/file.txt"},
+		{target: "/inputs/", want: "/inputs"},
+		{target: "inputs", wantErr: `container path "inputs" must be absolute`},
+		{target: "./inputs", wantErr: `container path "./inputs" must be absolute`},
+		{target: "", wantErr: "container path must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := cleanTarget(tt.target)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got %q with error %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLocalInputTarget(t *testing.T) {
+	hostPath := t.TempDir()
+	if _, err := parseLocalInput(hostPath + ":relative/path"); err == nil {
+		t.Fatal("expected a relative container path to be rejected")
+	}
+	if _, err := parseLocalInput(hostPath + ":"); err == nil {
+		t.Fatal("expected an empty container path to be rejected")
+	}
+
+	input, err := parseLocalInput(hostPath + ":/inputs/:rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.target != "/inputs" || !input.readWrite {
+		t.Fatalf("got target %q and readWrite %v, want /inputs and true", input.target, input.readWrite)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Report whether jobs of this type keep running rather than completing, so
+// there are no final results to wait for
+func isLongRunning(jobType string) bool {
+	return jobType == models.JobTypeService || jobType == models.JobTypeDaemon
+}
+
+// Builds a single task job, starting from the defaults of the original
+// copy-file-contents job and applying job options on top
+type jobBuilder struct {
+	job  models.Job
+	task *models.Task
+}
+
+// Changes one aspect of the job being built
+type jobOption func(*jobBuilder)
+
+func newJobBuilder() *jobBuilder {
+	task := &models.Task{
+		Name: "copy-file-contents",
+		Engine: &models.SpecConfig{
+			Type: "docker",
+			Params: map[string]any{
+				"Image":      defaultImage,
+				"Entrypoint": defaultEntrypoint,
+			},
+		},
+		Publisher: &models.SpecConfig{
+			Type: "local",
+		},
+		ResultPaths: []*models.ResultPath{
+			{
+				Name: "outputs",
+				Path: "/outputs",
+			},
+		},
+		ResourcesConfig: &models.ResourcesConfig{},
+	}
+
+	return &jobBuilder{
+		job: models.Job{
+			Name:      "copy-file-contents",
+			Namespace: "default",
+			Type:      models.JobTypeBatch,
+			Count:     1,
+			Priority:  50,
+			Meta:      make(map[string]string),
+			Labels:    make(map[string]string),
+			Tasks:     []*models.Task{task},
+		},
+		task: task,
+	}
+}
+
+// Start from an existing job spec, whose first task the task options apply to
+func newJobBuilderFrom(job models.Job) *jobBuilder {
+	return &jobBuilder{
+		job:  job,
+		task: job.Task(),
+	}
+}
+
+// Apply options in order, later ones overriding earlier ones
+func (b *jobBuilder) with(options ...jobOption) *jobBuilder {
+	for _, option := range options {
+		option(b)
+	}
+
+	return b
+}
+
+func (b *jobBuilder) build() models.Job {
+	return b.job
+}
+
+// Get the Docker engine params, switching the task to Docker if needed
+func (b *jobBuilder) dockerParams() map[string]any {
+	if !b.task.Engine.IsType("docker") {
+		b.task.Engine = &models.SpecConfig{
+			Type:   "docker",
+			Params: map[string]any{},
+		}
+	}
+
+	return b.task.Engine.Params
+}
+
+func withName(name string) jobOption {
+	return func(b *jobBuilder) { b.job.Name = name }
+}
+
+func withNamespace(namespace string) jobOption {
+	return func(b *jobBuilder) { b.job.Namespace = namespace }
+}
+
+func withLabels(labels map[string]string) jobOption {
+	return func(b *jobBuilder) {
+		for key, value := range labels {
+			b.job.Labels[key] = value
+		}
+	}
+}
+
+func withConstraints(constraints []*models.LabelSelectorRequirement) jobOption {
+	return func(b *jobBuilder) { b.job.Constraints = append(b.job.Constraints, constraints...) }
+}
+
+func withType(jobType string) jobOption {
+	return func(b *jobBuilder) { b.job.Type = jobType }
+}
+
+func withCount(count int) jobOption {
+	return func(b *jobBuilder) { b.job.Count = count }
+}
+
+func withPriority(priority int) jobOption {
+	return func(b *jobBuilder) { b.job.Priority = priority }
+}
+
+func withImage(image string) jobOption {
+	return func(b *jobBuilder) { b.dockerParams()["Image"] = image }
+}
+
+func withEntrypoint(entrypoint ...string) jobOption {
+	return func(b *jobBuilder) { b.dockerParams()["Entrypoint"] = entrypoint }
+}
+
+func withWorkingDirectory(dir string) jobOption {
+	return func(b *jobBuilder) { b.dockerParams()["WorkingDirectory"] = dir }
+}
+
+// Replace the task engine entirely, e.g. to run WASM
+func withEngine(engine *models.SpecConfig) jobOption {
+	return func(b *jobBuilder) { b.task.Engine = engine }
+}
+
+func withTaskName(name string) jobOption {
+	return func(b *jobBuilder) { b.task.Name = name }
+}
+
+func withEnv(env map[string]models.EnvVarValue) jobOption {
+	return func(b *jobBuilder) { b.task.Env = env }
+}
+
+func withInput(source *models.InputSource) jobOption {
+	return func(b *jobBuilder) { b.task.InputSources = append(b.task.InputSources, source) }
+}
+
+func withPublisher(publisher *models.SpecConfig) jobOption {
+	return func(b *jobBuilder) { b.task.Publisher = publisher }
+}
+
+// Replace the default outputs result path
+func withResultPaths(resultPaths []*models.ResultPath) jobOption {
+	return func(b *jobBuilder) { b.task.ResultPaths = resultPaths }
+}
+
+func withResources(resources models.ResourcesConfig) jobOption {
+	return func(b *jobBuilder) { b.task.ResourcesConfig = resources.Copy() }
+}
+
+// Limit how long the task may execute, in the whole seconds Bacalhau counts in
+func withTaskTimeout(timeout time.Duration) jobOption {
+	return func(b *jobBuilder) {
+		if b.task.Timeouts == nil {
+			b.task.Timeouts = &models.TimeoutConfig{}
+		}
+		b.task.Timeouts.ExecutionTimeout = int64((timeout + time.Second - 1) / time.Second)
+	}
+}
+
+// Check the job's publisher and result paths agree before submitting. A
+// publisher with nothing to publish fails retrieval later with no clear cause,
+// while result paths without a publisher are only dropped, so that just warns.
+func validateResults(job *models.Job) error {
+	task := job.Task()
+	if task == nil {
+		return nil
+	}
+
+	hasPublisher := task.Publisher != nil && task.Publisher.Type != ""
+	switch {
+	case hasPublisher && len(task.ResultPaths) == 0:
+		return fmt.Errorf("job publishes results with the %s publisher but has no result paths to publish", task.Publisher.Type)
+	case !hasPublisher && len(task.ResultPaths) > 0:
+		slog.Warn("Job has result paths but no publisher, so no results will be published", "job", job.Name, "resultPaths", len(task.ResultPaths))
+	}
+
+	return nil
+}
+
+// Translate the parsed flags into job options and build the job, or use the
+// -job-file spec with any overrides
+func getJob(opts *options) models.Job {
+	if opts.jobFile != nil {
+		return newJobBuilderFrom(*opts.jobFile).with(opts.jobOverrides...).build()
+	}
+
+	jobOptions := []jobOption{
+		withName(opts.name),
+		withTaskName(opts.taskName),
+		withNamespace(opts.namespace),
+		withLabels(opts.labels),
+		withConstraints(opts.constraints),
+		withType(opts.jobType),
+		withCount(opts.count),
+		withPriority(opts.priority),
+		withEnv(opts.env),
+		withPublisher(getPublisher(opts)),
+		withResources(opts.resources),
+	}
+
+	if opts.engine == "wasm" {
+		jobOptions = append(jobOptions, withEngine(getWasmEngine(opts)))
+	} else {
+		jobOptions = append(jobOptions, withImage(opts.image), withEntrypoint(opts.entrypoint...))
+		if opts.workdir != "" {
+			jobOptions = append(jobOptions, withWorkingDirectory(opts.workdir))
+		}
+	}
+
+	if len(opts.resultPaths) > 0 {
+		jobOptions = append(jobOptions, withResultPaths(opts.resultPaths))
+	}
+	if opts.taskTimeout > 0 {
+		jobOptions = append(jobOptions, withTaskTimeout(opts.taskTimeout))
+	}
+
+	for _, source := range getInputSources(opts) {
+		jobOptions = append(jobOptions, withInput(source))
+	}
+
+	return newJobBuilder().with(jobOptions...).build()
+}
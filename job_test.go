@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestGetJob(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// Expected job and task fields, and Docker engine params
+		jobName    string
+		count      int
+		priority   int
+		labels     map[string]string
+		image      string
+		entrypoint []string
+		workdir    any
+		env        map[string]models.EnvVarValue
+		publisher  *models.SpecConfig
+		resources  *models.ResourcesConfig
+	}{
+		{
+			name:       "defaults",
+			jobName:    "copy-file-contents",
+			count:      1,
+			priority:   50,
+			labels:     map[string]string{},
+			image:      defaultImage,
+			entrypoint: defaultEntrypoint,
+			publisher:  &models.SpecConfig{Type: "local"},
+			resources:  &models.ResourcesConfig{CPU: "0.5", Memory: "100m", GPU: "0"},
+		},
+		{
+			name: "representative flags",
+			args: []string{
+				"-name", "wordcount", "-count", "2", "-priority", "10", "-label", "team=data",
+				"-image", "alpine:3", "-entrypoint", "wc", "-entrypoint", "-l", "-workdir", "/work",
+				"-env", "MODE=fast", "-cpu", "1", "-memory", "1Gb",
+				"-publisher", "s3", "-publisher-bucket", "results", "-publisher-key", "runs/{jobID}",
+			},
+			jobName:    "wordcount",
+			count:      2,
+			priority:   10,
+			labels:     map[string]string{"team": "data"},
+			image:      "alpine:3",
+			entrypoint: []string{"wc", "-l"},
+			workdir:    "/work",
+			env:        map[string]models.EnvVarValue{"MODE": "fast"},
+			publisher:  &models.SpecConfig{Type: "s3", Params: map[string]any{"Bucket": "results", "Key": "runs/{jobID}"}},
+			resources:  &models.ResourcesConfig{CPU: "1", Memory: "1Gb", GPU: "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := getJob(testOptions(t, tt.args...))
+
+			if job.Name != tt.jobName || job.Type != models.JobTypeBatch || job.Count != tt.count || job.Priority != tt.priority {
+				t.Fatalf("got job %s of type %s, count %d and priority %d, want %s of type batch, count %d and priority %d",
+					job.Name, job.Type, job.Count, job.Priority, tt.jobName, tt.count, tt.priority)
+			}
+			if !reflect.DeepEqual(job.Labels, tt.labels) {
+				t.Fatalf("got labels %v, want %v", job.Labels, tt.labels)
+			}
+
+			task := job.Task()
+			params := task.Engine.Params
+			if task.Engine.Type != "docker" || params["Image"] != tt.image || params["WorkingDirectory"] != tt.workdir {
+				t.Fatalf("got engine %s with params %v, want docker running %s in %v", task.Engine.Type, params, tt.image, tt.workdir)
+			}
+			if !reflect.DeepEqual(params["Entrypoint"], tt.entrypoint) {
+				t.Fatalf("got entrypoint %v, want %v", params["Entrypoint"], tt.entrypoint)
+			}
+			if len(task.Env) > 0 || len(tt.env) > 0 {
+				if !reflect.DeepEqual(task.Env, tt.env) {
+					t.Fatalf("got env %v, want %v", task.Env, tt.env)
+				}
+			}
+			if !reflect.DeepEqual(task.Publisher, tt.publisher) {
+				t.Fatalf("got publisher %+v, want %+v", task.Publisher, tt.publisher)
+			}
+			if !reflect.DeepEqual(task.ResourcesConfig, tt.resources) {
+				t.Fatalf("got resources %+v, want %+v", task.ResourcesConfig, tt.resources)
+			}
+			wantResults := []*models.ResultPath{{Name: "outputs", Path: "/outputs"}}
+			if !reflect.DeepEqual(task.ResultPaths, wantResults) {
+				t.Fatalf("got result paths %v, want outputs:/outputs", task.ResultPaths)
+			}
+			if len(task.InputSources) != 1 || task.InputSources[0].Target != "/tmp" {
+				t.Fatalf("got inputs %v, want the default input at /tmp", task.InputSources)
+			}
+		})
+	}
+}
+
+func TestValidateResults(t *testing.T) {
+	resultPaths := []*models.ResultPath{{Name: "outputs", Path: "/outputs"}}
+	local := &models.SpecConfig{Type: "local"}
+
+	tests := []struct {
+		name        string
+		publisher   *models.SpecConfig
+		resultPaths []*models.ResultPath
+		wantErr     string
+		wantWarning bool
+	}{
+		{name: "publisher and result paths", publisher: local, resultPaths: resultPaths},
+		{name: "neither"},
+		{
+			name:      "publisher without result paths",
+			publisher: local,
+			wantErr:   "job publishes results with the local publisher but has no result paths to publish",
+		},
+		{name: "result paths without publisher", resultPaths: resultPaths, wantWarning: true},
+		{name: "result paths with an empty publisher", publisher: &models.SpecConfig{}, resultPaths: resultPaths, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			job := &models.Job{
+				Name:  "validate",
+				Tasks: []*models.Task{{Name: "main", Publisher: tt.publisher, ResultPaths: tt.resultPaths}},
+			}
+
+			err := validateResults(job)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			warned := strings.Contains(logs.String(), "Job has result paths but no publisher")
+			if warned != tt.wantWarning {
+				t.Fatalf("got warning %v, want %v, logs:\n%s", warned, tt.wantWarning, logs)
+			}
+		})
+	}
+}
+
+func TestWithTaskTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int64
+	}{
+		{timeout: 30 * time.Minute, want: 1800},
+		{timeout: 1500 * time.Millisecond, want: 2},
+		{timeout: time.Millisecond, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			job := getJob(testOptions(t, "-task-timeout", tt.timeout.String()))
+			if got := job.Task().Timeouts.ExecutionTimeout; got != tt.want {
+				t.Fatalf("got execution timeout %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskTimeoutWithJobFile(t *testing.T) {
+	spec := `
+Name: from-file
+Type: %s
+Tasks:
+  - Name: main
+    Engine:
+      Type: docker
+      Params:
+        Image: ubuntu:latest
+    Timeouts:
+      ExecutionTimeout: 60
+      QueueTimeout: 120
+`
+
+	t.Run("overrides the file", func(t *testing.T) {
+		path := writeTempFile(t, "job.yaml", []byte(fmt.Sprintf(spec, "batch")))
+		job := getJob(testOptions(t, "-job-file", path, "-task-timeout", "5m"))
+		want := &models.TimeoutConfig{ExecutionTimeout: 300, QueueTimeout: 120}
+		if got := job.Task().Timeouts; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got timeouts %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("file kept without the flag", func(t *testing.T) {
+		path := writeTempFile(t, "job.yaml", []byte(fmt.Sprintf(spec, "batch")))
+		job := getJob(testOptions(t, "-job-file", path))
+		if got := job.Task().Timeouts.ExecutionTimeout; got != 60 {
+			t.Fatalf("got execution timeout %d, want 60 from the file", got)
+		}
+	})
+
+	t.Run("rejected for long-running jobs", func(t *testing.T) {
+		path := writeTempFile(t, "job.yaml", []byte(fmt.Sprintf(spec, "service")))
+		_, err := parseFlags([]string{"-output-dir", t.TempDir(), "-job-file", path, "-task-timeout", "5m"})
+		if err == nil || err.Error() != "task-timeout can't be used with service jobs" {
+			t.Fatalf("got error %v, want task-timeout rejected", err)
+		}
+	})
+}
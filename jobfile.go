@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Read a job spec from a JSON or YAML file. YAML is converted to JSON first so
+// both formats use the field names of the JSON API.
+func loadJobFile(path string) (*models.Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading job file: %s", err.Error())
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" {
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error parsing job file %s: %s", path, err.Error())
+		}
+		data, err = json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing job file %s: %s", path, err.Error())
+		}
+	}
+
+	job := &models.Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, fmt.Errorf("error parsing job file %s: %s", path, err.Error())
+	}
+	if len(job.Tasks) == 0 {
+		return nil, fmt.Errorf("job file %s must define at least one task", path)
+	}
+
+	return job, nil
+}
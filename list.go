@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// List recently submitted jobs, most recent first
+func runList(args []string) int {
+	opts, err := parseListFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("Failed to parse flags", "error", err)
+		return 1
+	}
+	slog.SetDefault(opts.logger)
+	stopReporting, err := startReporting(opts)
+	if err != nil {
+		slog.Error("Failed to start reporting", "error", err)
+		return 1
+	}
+	defer stopReporting()
+
+	ctx, _, cancel := newRunContext(opts)
+	defer cancel()
+
+	requirements, err := labelRequirements(opts.labels)
+	if err != nil {
+		slog.Error("Invalid label filter", "error", err)
+		return 1
+	}
+
+	jobs := newJobsClient(opts)
+	resp, err := jobs.List(ctx, &apimodels.ListJobsRequest{
+		BaseListRequest: apimodels.BaseListRequest{
+			BaseGetRequest: apimodels.BaseGetRequest{
+				BaseRequest: apimodels.BaseRequest{Namespace: opts.namespace},
+			},
+			Limit:   uint32(opts.limit),
+			OrderBy: "created_at",
+			Reverse: true,
+		},
+		Labels: requirements,
+	})
+	if err != nil {
+		slog.Error("Failed to list jobs", "error", err)
+		return 1
+	}
+
+	if err := writeJobTable(os.Stdout, resp.Items); err != nil {
+		slog.Error("Failed to write jobs", "error", err)
+		return 1
+	}
+	if resp.NextToken != "" {
+		slog.Info("More jobs available, raise -limit to see them", "limit", opts.limit)
+	}
+
+	return 0
+}
+
+// Turn -label filters into requirements that jobs carry the exact value
+func labelRequirements(filters map[string]string) ([]labels.Requirement, error) {
+	keys := make([]string, 0, len(filters))
+	for key := range filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	requirements := make([]labels.Requirement, 0, len(keys))
+	for _, key := range keys {
+		requirement, err := labels.NewRequirement(key, selection.Equals, []string{filters[key]})
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *requirement)
+	}
+
+	return requirements, nil
+}
+
+// Print one aligned row per job
+func writeJobTable(w io.Writer, jobs []*models.Job) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATE\tCREATED")
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", job.ID, job.Name, job.State.StateType.String(),
+			job.GetCreateTime().Local().Format(time.DateTime))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Parse a -log-level value
+func parseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return lvl, fmt.Errorf("invalid log level %q: expected debug, info, warn or error", level)
+	}
+
+	return lvl, nil
+}
+
+// Build the logger for progress messages in the requested format
+func newLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+
+	return nil, fmt.Errorf("invalid log format %q: expected text or json", format)
+}
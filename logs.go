@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// How long to wait before asking for logs again when they aren't available yet
+const logRetryInterval = 2 * time.Second
+
+// Stream execution logs to w in the background. The returned function stops
+// the stream and waits for it to finish so output doesn't interleave with later
+// prints. Given a grace period, it first lets the stream drain for up to that
+// long, so output flushed just before the job finished isn't lost.
+func startFollowingLogs(ctx context.Context, jobs jobsAPI, jobID, executionID string, window logWindow, w io.Writer) func(grace time.Duration) {
+	// Stop along with the run until the job finishes, after which the grace
+	// period applies even if the run's timeout passes
+	logCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stopWithRun := context.AfterFunc(ctx, cancel)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		followLogs(logCtx, jobs, jobID, executionID, window, w)
+	}()
+
+	return func(grace time.Duration) {
+		if stopWithRun() && grace > 0 {
+			drainLogs(ctx, done, grace)
+		}
+		cancel()
+		<-done
+	}
+}
+
+// Wait for the log stream to end on its own, for up to grace or until interrupted
+func drainLogs(ctx context.Context, done <-chan struct{}, grace time.Duration) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	runDone := ctx.Done()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			slog.Debug("Stopped following logs after -follow-timeout", "timeout", grace)
+			return
+		case <-runDone:
+			// Keep tailing past the run's timeout, but not past an interrupt
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return
+			}
+			runDone = nil
+		}
+	}
+}
+
+// How much of the log history to show before following new output
+type logWindow struct {
+	// Only the last tail lines, or all of them when 0
+	tail int
+	// Only output newer than since, or all of it when 0
+	since time.Duration
+}
+
+// Follow logs until the stream ends or ctx is cancelled, retrying while logs are unavailable
+func followLogs(ctx context.Context, jobs jobsAPI, jobID, executionID string, window logWindow, w io.Writer) {
+	// The logs API can either send the whole history or skip it, so anything
+	// in between is done here
+	newOnly := false
+	if window.since > 0 {
+		// Log lines carry no timestamps to filter the history by
+		slog.Warn("Logs can't be filtered by time, following new output only", "since", window.since)
+		newOnly = true
+	} else if window.tail > 0 {
+		if err := writeLastLogs(ctx, jobs, jobID, executionID, window.tail, w); err != nil {
+			slog.Warn("Failed to get log history, following new output only", "error", err)
+		}
+		newOnly = true
+	}
+
+	for {
+		err := streamLogs(ctx, jobs, &apimodels.GetLogsRequest{
+			JobID:       jobID,
+			ExecutionID: executionID,
+			Tail:        newOnly,
+			Follow:      true,
+		}, w)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		slog.Info("Logs not available yet, retrying", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(logRetryInterval):
+		}
+	}
+}
+
+// Write the last n lines of the log history to w. Lines written between this
+// and following new output may be missed or repeated.
+func writeLastLogs(ctx context.Context, jobs jobsAPI, jobID, executionID string, n int, w io.Writer) error {
+	last := &lastLines{n: n}
+	if err := streamLogs(ctx, jobs, &apimodels.GetLogsRequest{
+		JobID:       jobID,
+		ExecutionID: executionID,
+	}, last); err != nil {
+		return err
+	}
+
+	for _, line := range last.lines {
+		if _, err := io.WriteString(w, line); err != nil {
+			return fmt.Errorf("error writing logs: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Writer keeping only the last n log lines written to it
+type lastLines struct {
+	n     int
+	lines []string
+}
+
+func (l *lastLines) Write(p []byte) (int, error) {
+	l.lines = append(l.lines, string(p))
+	if len(l.lines) > l.n {
+		l.lines = l.lines[len(l.lines)-l.n:]
+	}
+
+	return len(p), nil
+}
+
+// Where streamed logs go: stdout, unless stdout is reserved for the JSON
+// summary or a result file
+func logsOutput(opts *options) io.Writer {
+	if opts.outputFormat == "json" || opts.stdout {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+func streamLogs(ctx context.Context, jobs jobsAPI, req *apimodels.GetLogsRequest, w io.Writer) error {
+	logs, err := jobs.Logs(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case result, ok := <-logs:
+			if !ok {
+				return nil
+			}
+			if result.Err != nil {
+				return result.Err
+			}
+			if _, err := io.WriteString(w, result.Value.Line); err != nil {
+				return fmt.Errorf("error writing logs: %s", err.Error())
+			}
+		}
+	}
+}
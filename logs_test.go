@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestFollowLogsWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      logWindow
+		want        string
+		wantWarning bool
+	}{
+		{name: "whole history", want: "one\ntwo\nthree\n"},
+		{name: "last lines", window: logWindow{tail: 2}, want: "two\nthree\n"},
+		// The logs API can't filter by time, so only new output is followed
+		{name: "since", window: logWindow{since: time.Minute}, want: "", wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newFakeJobs(models.JobStateTypeRunning)
+			jobs.history = []string{"one\n", "two\n", "three\n"}
+			logs := captureLogs(t)
+
+			var out bytes.Buffer
+			followLogs(context.Background(), jobs, "job-1", "exec-1", tt.window, &out)
+			if out.String() != tt.want {
+				t.Fatalf("got output %q, want %q", out.String(), tt.want)
+			}
+			warned := strings.Contains(logs.String(), "Logs can't be filtered by time, following new output only")
+			if warned != tt.wantWarning {
+				t.Fatalf("got warning %v, want %v, logs:\n%s", warned, tt.wantWarning, logs)
+			}
+			if last := jobs.logRequests[len(jobs.logRequests)-1]; !last.Follow {
+				t.Fatalf("last logs request %+v doesn't follow new output", last)
+			}
+		})
+	}
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/bacalhau-project/bacalhau/pkg/models"
@@ -19,213 +19,402 @@ import (
 )
 
 func main() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	os.Exit(run(os.Args[1:]))
+}
 
-	// Start Bacalhau client
-	httpClient := client.NewHTTPClient("http://localhost:1234")
-	api := client.NewAPI(httpClient)
+// Dispatch to the requested command, returning the exit code
+func run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "get":
+			return runGet(args[1:])
+		case "list":
+			return runList(args[1:])
+		case "describe":
+			return runDescribe(args[1:])
+		case "stop":
+			return runStop(args[1:])
+		case "schema":
+			return runSchema(args[1:])
+		}
+	}
 
-	// Prepare job
-	job := getJob()
+	return runSubmit(args)
+}
 
-	// Submit job
-	resp, err := api.Jobs().Put(ctx, &apimodels.PutJobRequest{
-		Job: &job,
-	})
+// Submit the job, wait for it to finish and retrieve its outputs
+func runSubmit(args []string) int {
+	opts, err := parseFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 	if err != nil {
-		log.Fatalf("Failed to submit job: %v", err)
+		slog.Error("Failed to parse flags", "error", err)
+		return 1
 	}
-	fmt.Printf("Job submitted successfully! ID: %s\n", resp.JobID)
+	slog.SetDefault(opts.logger)
+	stopReporting, err := startReporting(opts)
+	if err != nil {
+		slog.Error("Failed to start reporting", "error", err)
+		return 1
+	}
+	defer stopReporting()
+
+	ctx, stopSignals, cancel := newRunContext(opts)
+	defer cancel()
 
-	// Poll job
-	for {
-		fmt.Println("Checking job status...")
+	// Stage -input-glob and -input-inline files, keeping them for the job when
+	// not waiting for it
+	cleanupInputs, err := stageInputs(opts)
+	if err != nil {
+		slog.Error("Failed to stage inputs", "error", err)
+		return 1
+	}
+	defer func() {
+		if opts.wait || opts.dryRun {
+			cleanupInputs()
+		}
+	}()
 
-		jobInfo, err := api.Jobs().Get(ctx, &apimodels.GetJobRequest{
-			JobID:   resp.JobID,
-			Include: "executions",
-		})
+	if len(opts.batchInputs) > 0 {
+		api, err := connectAPI(ctx, opts)
 		if err != nil {
-			log.Fatalf("Failed to get job status: %v", err)
+			return 1
 		}
+		return runBatch(ctx, stopSignals, api.Jobs(), opts)
+	}
 
-		stateType := jobInfo.Job.State.StateType
-		if stateType == models.JobStateTypeRunning {
-			fmt.Println("Job is running")
-		} else if stateType == models.JobStateTypeCompleted {
-			fmt.Println("Job completed successfully!")
-
-			outputPath, err := retrieveOutputs(ctx, api, resp.JobID)
-			if err != nil {
-				fmt.Printf("unable to retrieve results: %s", err)
-			}
-			fmt.Printf("Results available in: %s\n", outputPath)
-
-			break
-		} else if stateType == models.JobStateTypeFailed {
-			fmt.Printf("Job failed: %s\n", jobInfo.Job.State.Message)
-			break
-		} else if stateType == models.JobStateTypeStopped {
-			fmt.Println("Job was stopped")
-			break
-		}
+	// Point entrypoint templates at the inputs now that they're staged
+	if err := renderEntrypoint(opts); err != nil {
+		slog.Error("Failed to render entrypoint", "error", err)
+		return 1
+	}
 
-		jsonData, _ := json.MarshalIndent(jobInfo.Job, "", "  ")
+	// Prepare job
+	job := getJob(opts)
+	if err := validateResults(&job); err != nil {
+		slog.Error("Invalid job", "error", err)
+		return 1
+	}
+
+	if opts.dryRun {
+		jsonData, err := encodeJob(job, "json")
+		if err != nil {
+			slog.Error("Failed to encode job", "error", err)
+			return 1
+		}
 		fmt.Println(string(jsonData))
 
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func getJob() models.Job {
-	return models.Job{
-		Name:      "copy-file-contents",
-		Namespace: "default",
-		Type:      "batch",
-		Count:     1,
-		Priority:  50,
-		Meta:      make(map[string]string),
-		Labels:    make(map[string]string),
-		Tasks: []*models.Task{
-			{
-				Name: "copy-file-contents",
-				Engine: &models.SpecConfig{
-					Type: "docker",
-					Params: map[string]any{
-						"Image": "ubuntu:latest",
-						"Entrypoint": []string{
-							"/bin/sh",
-							"-c",
-							"cat /tmp/input.txt > /outputs/output.txt",
-						},
-					},
-				},
-				InputSources: []*models.InputSource{&models.InputSource{
-					Source: &models.SpecConfig{
-						Type: "localDirectory",
-						Params: map[string]any{
-							"SourcePath": getInputsPath(),
-							"ReadWrite":  true,
-						},
-					},
-					Target: "/tmp",
-				}},
-				Publisher: &models.SpecConfig{
-					Type: "local",
-				},
-				ResultPaths: []*models.ResultPath{
-					{
-						Name: "outputs",
-						Path: "/outputs",
-					},
-				},
-				ResourcesConfig: &models.ResourcesConfig{
-					CPU:    "0.5",
-					Memory: "100m",
-					GPU:    "0",
-				},
-			},
-		},
+		return 0
 	}
-}
 
-// Get absolute path for inputs
-func getInputsPath() string {
-	cwd, err := os.Getwd()
+	// Start Bacalhau client
+	api, err := connectAPI(ctx, opts)
 	if err != nil {
-		log.Fatalf("Failed to get current working directory: %v", err)
+		return 1
 	}
+	jobs := api.Jobs()
 
-	return filepath.Join(cwd, "inputs")
-}
-
-func retrieveOutputs(ctx context.Context, api client.API, jobID string) (string, error) {
-	results, err := api.Jobs().Results(ctx, &apimodels.ListJobResultsRequest{
-		JobID: jobID,
-	})
+	// Submit job
+	jobID, timeline, err := submitAndRecord(ctx, jobs, &job, opts)
 	if err != nil {
-		fmt.Printf("error retrieving results: %s", err)
+		slog.Error("Failed to submit job", "error", err)
+		return 1
 	}
-	resultsURL := results.Items[0].Params["URL"].(string)
 
-	// Prepare target file
-	resultsDir := "./outputs"
-	tarballPath := filepath.Join(resultsDir, fmt.Sprintf("%s.tar.gz", jobID))
-	out, err := os.Create(tarballPath)
-	if err != nil {
-		return "", fmt.Errorf("error creating file: %s", err.Error())
+	if !opts.wait {
+		// Keep stdout to the job ID alone so scripts can capture it
+		fmt.Println(jobID)
+		return 0
 	}
-	defer out.Close()
 
-	// Get data from Bacalhau
-	resp, err := http.Get(resultsURL)
+	// Poll job, resubmitting it while it fails if asked to
+	jobID, jobInfo, err := waitForFinalState(ctx, stopSignals, jobs, job, jobID, opts, timeline)
 	if err != nil {
-		return "", fmt.Errorf("error making GET request: %s", err.Error())
+		return 1
 	}
-	defer resp.Body.Close()
+	// Report the timeline once outputs have been retrieved, or the run ends
+	defer logTimeline(jobID, timeline)
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
+	finalJob := jobInfo.Job
+	if finalJob.State.StateType == models.JobStateTypeRunning && opts.watch {
+		return watchOutputs(ctx, jobs, finalJob, opts)
+	}
+	if err := reportFinalState(jobInfo); err != nil {
+		return 1
+	}
+	switch {
+	case finalJob.State.StateType != models.JobStateTypeCompleted:
+		return printSummary(finalJob, &retrievalResult{}, opts)
+	case opts.noResults:
+		slog.Info("Not retrieving results with -no-results", "jobID", jobID)
+		return printSummary(finalJob, &retrievalResult{}, opts)
 	}
 
-	// Write the body to the target
-	_, err = io.Copy(out, resp.Body)
+	return fetchOutputs(ctx, jobs, finalJob, opts)
+}
+
+// Submit the job and start its timeline. Any attrs are added to the log of
+// the submission.
+func submitAndRecord(ctx context.Context, jobs jobsAPI, job *models.Job, opts *options, attrs ...any) (string, *jobTimeline, error) {
+	jobID, err := submitJob(ctx, jobs, job, opts.submitRetries)
 	if err != nil {
-		return "", fmt.Errorf("error writing to file: %s", err.Error())
+		opts.events.emit(event{Event: "error", Error: err.Error()})
+		return "", nil, err
 	}
+	slog.Info("Job submitted successfully", append([]any{"jobID", jobID}, attrs...)...)
+	opts.events.emit(event{Event: "submitted", JobID: jobID})
+	opts.metrics.jobSubmitted()
+	timeline := &jobTimeline{}
+	timeline.record("submitted", time.Now())
 
-	// Extract the tar.gz file
-	outputPath := filepath.Join(resultsDir, jobID)
-	err = extractTarGz(tarballPath, outputPath)
-	if err != nil {
-		return "", fmt.Errorf("error extracting tar.gz file: %s", err.Error())
+	return jobID, timeline, nil
+}
+
+// Poll the job until it's finished, resubmitting it while it fails if asked
+// to, and return the ID and final state of the last attempt. A job that is
+// given up on is stopped, and any error has been logged.
+func waitForFinalState(ctx context.Context, stopSignals context.CancelFunc, jobs jobsAPI, job models.Job, jobID string, opts *options, timeline *jobTimeline) (string, *apimodels.GetJobResponse, error) {
+	jobInfo, err := waitForJob(ctx, jobs, jobID, opts, timeline)
+	if err == nil {
+		jobID, jobInfo, err = retryFailedJob(ctx, jobs, job, jobID, jobInfo, opts, timeline)
+	}
+	if err == nil {
+		return jobID, jobInfo, nil
+	}
+
+	switch {
+	case ctx.Err() != nil && jobID != "":
+		// Restore default signal handling so a second interrupt exits immediately
+		stopSignals()
+		abandonJob(jobs, jobID, ctx.Err())
+	case errors.Is(err, errPollBudgetExhausted):
+		abandonJob(jobs, jobID, err)
+	default:
+		slog.Error("Failed to get job status", "jobID", jobID, "error", err)
+	}
+
+	return jobID, nil, err
+}
+
+// Log how the job ended, returning an error unless it completed or is a
+// long-running job that's still running
+func reportFinalState(jobInfo *apimodels.GetJobResponse) error {
+	job := jobInfo.Job
+	switch job.State.StateType {
+	case models.JobStateTypeCompleted:
+		slog.Info("Job completed successfully", "jobID", job.ID)
+		return nil
+	case models.JobStateTypeRunning:
+		// Long-running jobs have no final results, so leave them running
+		slog.Info("Job is running, not waiting for results", "jobID", job.ID, "type", job.Type)
+		return nil
+	case models.JobStateTypeFailed:
+		slog.Error("Job failed", "jobID", job.ID, "message", job.State.Message)
+		logFailedExecutions(jobInfo)
+		return fmt.Errorf("job failed: %s", job.State.Message)
+	case models.JobStateTypeStopped:
+		slog.Warn("Job was stopped", "jobID", job.ID)
+		return errors.New("job was stopped")
 	}
 
-	return outputPath, nil
+	return fmt.Errorf("job ended in state %s", job.State.StateType.String())
 }
 
-func extractTarGz(src, dst string) error {
-	file, err := os.Open(src)
+func logTimeline(jobID string, timeline *jobTimeline) {
+	end := time.Now()
+	slog.Info("Job timeline", "jobID", jobID, "timeline", timeline.format(end), "elapsed", timeline.elapsed(end).Round(time.Millisecond))
+}
+
+// Retrieve the outputs of a previously submitted job
+func runGet(args []string) int {
+	opts, jobID, err := parseGetFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 	if err != nil {
-		return err
+		slog.Error("Failed to parse flags", "error", err)
+		return 1
 	}
-	defer file.Close()
+	slog.SetDefault(opts.logger)
+	stopReporting, err := startReporting(opts)
+	if err != nil {
+		slog.Error("Failed to start reporting", "error", err)
+		return 1
+	}
+	defer stopReporting()
+
+	ctx, _, cancel := newRunContext(opts)
+	defer cancel()
 
-	gzr, err := gzip.NewReader(file)
+	jobs := newJobsClient(opts)
+
+	jobInfo, err := jobs.Get(ctx, &apimodels.GetJobRequest{
+		JobID: jobID,
+	})
 	if err != nil {
-		return err
+		slog.Error("Failed to get job", "jobID", jobID, "error", err)
+		return 1
 	}
-	defer gzr.Close()
+	if opts.watch {
+		return watchOutputs(ctx, jobs, jobInfo.Job, opts)
+	}
+
+	return fetchOutputs(ctx, jobs, jobInfo.Job, opts)
+}
 
-	tr := tar.NewReader(gzr)
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
+// Retrieve a job's outputs and report where they landed
+func fetchOutputs(ctx context.Context, jobs jobsAPI, job *models.Job, opts *options) int {
+	result, err := retrieveOutputs(ctx, jobs, job, opts)
+	if err != nil {
+		slog.Error("Unable to retrieve results", "jobID", job.ID, "error", err)
+		var partial partialOutputError
+		if errors.As(err, &partial) {
+			cleanupPartialOutputs(job.ID, partial.path, opts.cleanupOnError)
 		}
+		opts.events.emit(event{Event: "error", JobID: job.ID, Error: err.Error()})
+		return 1
+	}
+	if opts.stdout {
+		slog.Info("Result file written to stdout", "jobID", job.ID, "file", opts.resultFile)
+		return 0
+	}
+	if opts.noExtract {
+		slog.Info("Results downloaded without extracting", "jobID", job.ID, "archive", result.path)
+	} else {
+		slog.Info("Results available", "jobID", job.ID, "path", result.path)
+	}
+	slog.Info("Retrieval finished", "jobID", job.ID,
+		"bytesDownloaded", result.bytesDownloaded,
+		"filesExtracted", result.filesExtracted,
+		"bytesExtracted", result.bytesExtracted,
+		"duration", result.duration.Round(time.Millisecond))
+	if result.treeHash != "" {
+		slog.Info("Output tree hash", "jobID", job.ID, "sha256", result.treeHash)
+	}
+	opts.events.emit(event{Event: "results_available", JobID: job.ID, Path: result.path})
+
+	return printSummary(job, result, opts)
+}
+
+// Print the run summary to stdout with -output json
+func printSummary(job *models.Job, result *retrievalResult, opts *options) int {
+	if opts.outputFormat != "json" {
+		return 0
+	}
+
+	summary := newRunSummary(job, result.path, result.files)
+	summary.TreeSHA256 = result.treeHash
+	if opts.noExtract {
+		// Report the archive rather than an output directory that was never created
+		summary.OutputPath, summary.Archive = "", result.path
+	}
+	if err := writeSummary(os.Stdout, summary); err != nil {
+		slog.Error("Failed to write summary", "jobID", job.ID, "error", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Open -events-file and start serving -metrics-addr once the flags are parsed.
+// The returned function closes both when the command exits.
+func startReporting(opts *options) (func(), error) {
+	stopMetrics := func() {}
+	if opts.metricsAddr != "" {
+		opts.metrics = newMetricsRegistry()
+		stop, err := serveMetrics(opts.metricsAddr, opts.metrics)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		stopMetrics = stop
+	}
 
-		target := filepath.Join(dst, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, 0755); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(f, tr); err != nil {
-				f.Close()
-				return err
-			}
-			f.Close()
+	events, err := openEventEmitter(opts.eventsFile)
+	if err != nil {
+		stopMetrics()
+		return nil, err
+	}
+	opts.events = events
+
+	return func() {
+		opts.events.close()
+		stopMetrics()
+	}, nil
+}
+
+// Build the root context, cancelled on interrupt and bounded by -timeout. The
+// returned stop function restores default signal handling and cancel releases
+// everything.
+func newRunContext(opts *options) (context.Context, context.CancelFunc, context.CancelFunc) {
+	// Cancel on interrupt so the remote job can be stopped before exiting
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if opts.timeout == 0 {
+		return ctx, stopSignals, stopSignals
+	}
+
+	ctx, cancelTimeout := context.WithTimeout(ctx, opts.timeout)
+	return ctx, stopSignals, func() {
+		cancelTimeout()
+		stopSignals()
+	}
+}
+
+// Start the Bacalhau client and, unless -skip-preflight is given, check the
+// orchestrator is reachable. Failures are logged.
+func connectAPI(ctx context.Context, opts *options) (client.API, error) {
+	api := newAPIClient(opts)
+	if !opts.skipPreflight {
+		if err := checkOrchestrator(ctx, api.Agent(), opts.apiHost); err != nil {
+			slog.Error("Preflight check failed, pass -skip-preflight to submit anyway", "error", err)
+			return nil, err
 		}
 	}
-	return nil
+
+	return api, nil
+}
+
+// Start the Bacalhau client for the jobs API
+func newJobsClient(opts *options) jobsAPI {
+	return newAPIClient(opts).Jobs()
+}
+
+// Start the Bacalhau client
+func newAPIClient(opts *options) client.API {
+	httpClient := client.NewHTTPClient(opts.apiHost, clientOptions(opts)...)
+	if opts.apiToken == "" {
+		return client.NewAPI(httpClient)
+	}
+
+	// Attach the token to every request without running an interactive auth flow
+	authClient := &client.AuthenticatingClient{
+		Client: httpClient,
+		Credential: &apimodels.HTTPCredential{
+			Scheme: "Bearer",
+			Value:  opts.apiToken,
+		},
+		NewAuthenticationFlowEnabled: true,
+	}
+	return client.NewAPI(authClient)
+}
+
+// Translate the client flags into Bacalhau client options
+func clientOptions(opts *options) []client.OptionFn {
+	var clientOpts []client.OptionFn
+	if strings.HasPrefix(opts.apiHost, "https://") || opts.tlsConfig != nil {
+		// Log streaming dials a websocket, which only uses TLS when told to
+		clientOpts = append(clientOpts, client.WithTLS(true))
+	}
+	if opts.tlsConfig == nil {
+		return clientOpts
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.tlsConfig
+
+	return append(clientOpts,
+		client.WithHTTPClient(&http.Client{Transport: transport}),
+		client.WithCACertificate(opts.tlsCAFile),
+		client.WithInsecureTLS(opts.tlsInsecure),
+	)
 }
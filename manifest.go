@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// File written into each job's output directory
+const manifestFileName = "manifest.json"
+
+// Provenance record of a run, written alongside its extracted outputs
+type runManifest struct {
+	JobID       string          `json:"jobID"`
+	SubmittedAt time.Time       `json:"submittedAt"`
+	CompletedAt time.Time       `json:"completedAt"`
+	State       string          `json:"state"`
+	ResultURL   string          `json:"resultURL,omitempty"`
+	ResultURLs  []string        `json:"resultURLs,omitempty"`
+	Inputs      []manifestInput `json:"inputs"`
+}
+
+// An input source as it was resolved for the job
+type manifestInput struct {
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Build the manifest from the job as recorded by the orchestrator, with the
+// URL of each result that was retrieved
+func newRunManifest(job *models.Job, resultURLs ...string) runManifest {
+	manifest := runManifest{
+		JobID:       job.ID,
+		SubmittedAt: job.GetCreateTime(),
+		CompletedAt: job.GetModifyTime(),
+		State:       job.State.StateType.String(),
+		Inputs:      []manifestInput{},
+	}
+	if len(resultURLs) == 1 {
+		manifest.ResultURL = resultURLs[0]
+	} else {
+		manifest.ResultURLs = resultURLs
+	}
+
+	for _, task := range job.Tasks {
+		for _, input := range task.InputSources {
+			manifest.Inputs = append(manifest.Inputs, manifestInput{
+				Type:   input.Source.Type,
+				Source: describeInputSource(input.Source),
+				Target: input.Target,
+			})
+		}
+	}
+
+	return manifest
+}
+
+// Get a single string naming where an input source's data comes from
+func describeInputSource(source *models.SpecConfig) string {
+	switch source.Type {
+	case "localDirectory":
+		return fmt.Sprint(source.Params["SourcePath"])
+	case "s3":
+		return fmt.Sprintf("s3://%v/%v", source.Params["Bucket"], source.Params["Key"])
+	case "urlDownload":
+		return fmt.Sprint(source.Params["URL"])
+	case "ipfs":
+		return fmt.Sprintf("ipfs://%v", source.Params["CID"])
+	}
+
+	return ""
+}
+
+// Record the manifest in the output path. A manifest.json the job produced
+// itself is only replaced when overwrite is set.
+func writeManifest(outputPath string, manifest runManifest, overwrite bool) error {
+	target := filepath.Join(outputPath, manifestFileName)
+	if err := checkFileFree(target, overwrite); err != nil {
+		return fmt.Errorf("error writing manifest: %s", err.Error())
+	}
+
+	jsonData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %s", err.Error())
+	}
+
+	err = os.WriteFile(target, append(jsonData, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestDescribeInputSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source *models.SpecConfig
+		want   string
+	}{
+		{
+			name:   "local directory",
+			source: &models.SpecConfig{Type: "localDirectory", Params: map[string]any{"SourcePath": "/data/in"}},
+			want:   "/data/in",
+		},
+		{
+			name:   "s3",
+			source: &models.SpecConfig{Type: "s3", Params: map[string]any{"Bucket": "inputs", "Key": "runs/1/"}},
+			want:   "s3://inputs/runs/1/",
+		},
+		{
+			name:   "url",
+			source: &models.SpecConfig{Type: "urlDownload", Params: map[string]any{"URL": "https://example.com/in.csv"}},
+			want:   "https://example.com/in.csv",
+		},
+		{
+			name:   "ipfs",
+			source: &models.SpecConfig{Type: "ipfs", Params: map[string]any{"CID": "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"}},
+			want:   "ipfs://QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG",
+		},
+		{name: "unknown", source: &models.SpecConfig{Type: "other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeInputSource(tt.source); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunManifestIPFSInput(t *testing.T) {
+	const cid = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+	job := getJob(testOptions(t, "-input-ipfs", "ipfs://"+cid+":/data"))
+
+	manifest := newRunManifest(&job)
+	want := manifestInput{Type: "ipfs", Source: "ipfs://" + cid, Target: "/data"}
+	for _, input := range manifest.Inputs {
+		if input == want {
+			return
+		}
+	}
+	t.Fatalf("got inputs %+v, want one of %+v", manifest.Inputs, want)
+}
+
+func TestWriteManifestKeepsJobManifest(t *testing.T) {
+	manifest := runManifest{JobID: "job-1"}
+	tests := []struct {
+		name      string
+		existing  string
+		overwrite bool
+		wantErr   string
+	}{
+		{name: "no manifest"},
+		{name: "job manifest", existing: "{\"from\": \"job\"}\n", wantErr: "pass -overwrite to replace it"},
+		{name: "job manifest with overwrite", existing: "{\"from\": \"job\"}\n", overwrite: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, manifestFileName)
+			if tt.existing != "" {
+				if err := os.WriteFile(target, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := writeManifest(dir, manifest, tt.overwrite)
+			data, readErr := os.ReadFile(target)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected %q error, got %v", tt.wantErr, err)
+				}
+				if string(data) != tt.existing {
+					t.Fatalf("job manifest was replaced with %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), `"job-1"`) {
+				t.Fatalf("got manifest %q, want the run's", data)
+			}
+		})
+	}
+}
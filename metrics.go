@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds, in seconds, of the job duration histogram's buckets
+var jobDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Counters and histograms served in the Prometheus text format on
+// -metrics-addr. A nil registry discards everything, so callers don't need to
+// check whether metrics were asked for.
+type metricsRegistry struct {
+	mu sync.Mutex
+
+	submitted         int64
+	finished          map[string]int64
+	retrievalFailures int64
+	downloadedBytes   int64
+
+	// Observations per bucket of jobDurationBuckets, not cumulative
+	durationCounts []int64
+	durationSum    float64
+	durationCount  int64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		finished:       map[string]int64{},
+		durationCounts: make([]int64, len(jobDurationBuckets)),
+	}
+}
+
+// Listen on addr and serve the registry at /metrics in the background until the
+// returned function stops the server
+func serveMetrics(addr string, m *metricsRegistry) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics: %s", err.Error())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.writeTo(w)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+
+	return func() { server.Close() }, nil
+}
+
+func (m *metricsRegistry) jobSubmitted() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.submitted++
+}
+
+// Count a job that reached a terminal state, and how long it took to get there
+func (m *metricsRegistry) jobFinished(state string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finished[strings.ToLower(state)]++
+
+	seconds := duration.Seconds()
+	// Observations past the last bucket only count towards +Inf
+	if i, _ := slices.BinarySearch(jobDurationBuckets, seconds); i < len(jobDurationBuckets) {
+		m.durationCounts[i]++
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+func (m *metricsRegistry) downloaded(bytes int64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloadedBytes += bytes
+}
+
+func (m *metricsRegistry) retrievalFailed() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retrievalFailures++
+}
+
+// Write every metric in the Prometheus text exposition format
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, "bacalhau_poc_jobs_submitted_total", "counter", "Jobs submitted, including resubmissions.")
+	fmt.Fprintf(w, "bacalhau_poc_jobs_submitted_total %d\n", m.submitted)
+
+	writeMetricHeader(w, "bacalhau_poc_jobs_finished_total", "counter", "Jobs that reached a terminal state, by state.")
+	states := make([]string, 0, len(m.finished))
+	for state := range m.finished {
+		states = append(states, state)
+	}
+	slices.Sort(states)
+	for _, state := range states {
+		fmt.Fprintf(w, "bacalhau_poc_jobs_finished_total{state=%q} %d\n", state, m.finished[state])
+	}
+
+	writeMetricHeader(w, "bacalhau_poc_job_duration_seconds", "histogram", "Time spent waiting for a job to reach a terminal state.")
+	var cumulative int64
+	for i, bound := range jobDurationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(w, "bacalhau_poc_job_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "bacalhau_poc_job_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "bacalhau_poc_job_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "bacalhau_poc_job_duration_seconds_count %d\n", m.durationCount)
+
+	writeMetricHeader(w, "bacalhau_poc_downloaded_bytes_total", "counter", "Bytes of result archives downloaded.")
+	fmt.Fprintf(w, "bacalhau_poc_downloaded_bytes_total %d\n", m.downloadedBytes)
+
+	writeMetricHeader(w, "bacalhau_poc_retrieval_failures_total", "counter", "Failed attempts to retrieve a job's results.")
+	fmt.Fprintf(w, "bacalhau_poc_retrieval_failures_total %d\n", m.retrievalFailures)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// Returned by waitForJob when -max-polls status checks pass without the job
+// reaching a terminal state
+var errPollBudgetExhausted = errors.New("poll budget exhausted")
+
+// Delay before the first submission retry, doubled for each further attempt
+const submitRetryBackoff = 1 * time.Second
+
+// Submit the job and return its ID, retrying up to retries times while the
+// orchestrator is unreachable or overloaded. Rejected jobs fail straight away.
+func submitJob(ctx context.Context, jobs jobsAPI, job *models.Job, retries int) (string, error) {
+	backoff := submitRetryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := jobs.Put(ctx, &apimodels.PutJobRequest{
+			Job: job,
+		})
+		if err == nil {
+			// Warnings don't stop the job, but often point at a mistake in the spec
+			for _, warning := range resp.Warnings {
+				slog.Warn("Orchestrator warning", "jobID", resp.JobID, "warning", warning)
+			}
+			return resp.JobID, nil
+		}
+		if ctx.Err() != nil || !isRetryableAPIError(err) || attempt > retries {
+			return "", err
+		}
+
+		slog.Warn("Submission failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Resubmit the same spec while the job fails, up to -retries-on-failure times,
+// returning the ID and final state of the last attempt. Every attempt is
+// recorded on the same timeline and bounded by the same context.
+func retryFailedJob(ctx context.Context, jobs jobsAPI, job models.Job, jobID string, jobInfo *apimodels.GetJobResponse, opts *options, timeline *jobTimeline) (string, *apimodels.GetJobResponse, error) {
+	for attempt := 1; attempt <= opts.failureRetries && jobInfo.Job.State.StateType == models.JobStateTypeFailed; attempt++ {
+		slog.Warn("Job failed, resubmitting", "jobID", jobID, "message", jobInfo.Job.State.Message,
+			"attempt", attempt, "retries", opts.failureRetries)
+		logFailedExecutions(jobInfo)
+
+		// Submit a fresh copy so nothing set on the last attempt carries over
+		var err error
+		jobID, err = submitJob(ctx, jobs, job.Copy(), opts.submitRetries)
+		if err != nil {
+			return "", nil, fmt.Errorf("error resubmitting job: %s", err.Error())
+		}
+		slog.Info("Job resubmitted", "jobID", jobID, "attempt", attempt)
+		opts.events.emit(event{Event: "submitted", JobID: jobID})
+		opts.metrics.jobSubmitted()
+		timeline.record("resubmitted", time.Now())
+
+		if jobInfo, err = waitForJob(ctx, jobs, jobID, opts, timeline); err != nil {
+			return jobID, nil, err
+		}
+	}
+
+	return jobID, jobInfo, nil
+}
+
+// Poll the job until it reaches a terminal state, backing off while the state
+// is unchanged. Service and daemon jobs are returned as soon as they are running.
+// The last response is returned with the job's executions.
+func waitForJob(ctx context.Context, jobs jobsAPI, jobID string, opts *options, timeline *jobTimeline) (*apimodels.GetJobResponse, error) {
+	interval := opts.pollMin
+	var lastState models.JobStateType
+	stateSince := time.Now()
+	started := stateSince
+
+	// Once the job has finished, give the log stream time to catch up
+	stopLogs := func(time.Duration) {}
+	var logsGrace time.Duration
+	defer func() { stopLogs(logsGrace) }()
+	following := false
+	executionStates := map[string]models.ExecutionStateType{}
+
+	for polls := 1; ; polls++ {
+		slog.Debug("Checking job status", "jobID", jobID)
+
+		jobInfo, err := getJobStatus(ctx, jobs, jobID, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.verbose {
+			logExecutionChanges(jobInfo, executionStates)
+		}
+
+		stateType := jobInfo.Job.State.StateType
+		timeline.record(strings.ToLower(stateType.String()), time.Now())
+		if stateType != lastState {
+			interval = opts.pollMin
+			lastState = stateType
+			stateSince = time.Now()
+			slog.Info("Job state changed", "jobID", jobID, "state", stateType.String())
+			opts.events.emit(event{Event: "state_change", JobID: jobID, State: stateType.String()})
+		}
+
+		switch stateType {
+		case models.JobStateTypeCompleted, models.JobStateTypeFailed, models.JobStateTypeStopped:
+			logsGrace = opts.followTimeout
+			opts.metrics.jobFinished(stateType.String(), time.Since(started))
+			return jobInfo, nil
+		case models.JobStateTypeRunning:
+			if isLongRunning(jobInfo.Job.Type) {
+				return jobInfo, nil
+			}
+
+			if opts.follow && !following {
+				following = true
+				window := logWindow{tail: opts.logTail, since: opts.logSince}
+				stopLogs = startFollowingLogs(ctx, jobs, jobID, runningExecutionID(jobInfo), window, logsOutput(opts))
+			}
+		case models.JobStateTypeUndefined, models.JobStateTypePending, models.JobStateTypeQueued:
+			logWaiting(jobInfo, time.Since(stateSince))
+		}
+
+		if opts.dumpEachPoll {
+			// Same dump as the describe command, on a single line
+			var jsonData bytes.Buffer
+			if data, err := encodeJob(jobInfo, "json"); err == nil && json.Compact(&jsonData, data) == nil {
+				slog.Info("Job status", "jobID", jobID, "job", json.RawMessage(jsonData.Bytes()))
+			}
+		}
+
+		if opts.maxPolls > 0 && polls >= opts.maxPolls {
+			return nil, fmt.Errorf("%w after %d status checks", errPollBudgetExhausted, polls)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, opts.pollMax)
+	}
+}
+
+// Get the job with its executions, retrying up to -poll-retries times in a row
+// while the orchestrator is unreachable or overloaded
+func getJobStatus(ctx context.Context, jobs jobsAPI, jobID string, opts *options) (*apimodels.GetJobResponse, error) {
+	backoff := opts.pollMin
+	for attempt := 1; ; attempt++ {
+		jobInfo, err := jobs.Get(ctx, &apimodels.GetJobRequest{
+			JobID:   jobID,
+			Include: "executions",
+		})
+		if err == nil {
+			return jobInfo, nil
+		}
+		if ctx.Err() != nil || !isRetryableAPIError(err) || attempt > opts.pollRetries {
+			return nil, err
+		}
+
+		slog.Warn("Failed to check job status, retrying", "jobID", jobID, "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, opts.pollMax)
+	}
+}
+
+// Explain what a job that hasn't started yet is waiting for and for how long,
+// so a job that looks stuck can be told apart from one that is just queued
+func logWaiting(jobInfo *apimodels.GetJobResponse, waiting time.Duration) {
+	job := jobInfo.Job
+	attrs := []any{
+		"jobID", job.ID,
+		"state", job.State.StateType.String(),
+		"reason", waitingReason(jobInfo),
+		"waiting", waiting.Round(time.Second),
+	}
+	if job.State.Message != "" {
+		attrs = append(attrs, "message", job.State.Message)
+	}
+	slog.Info("Job has not started", attrs...)
+}
+
+// Describe the furthest an unstarted job has got towards being placed on a node
+func waitingReason(jobInfo *apimodels.GetJobResponse) string {
+	if jobInfo.Executions != nil {
+		for _, execution := range jobInfo.Executions.Items {
+			switch execution.ComputeState.StateType {
+			case models.ExecutionStateNew, models.ExecutionStateAskForBid:
+				return "waiting for a compute node to accept the job"
+			case models.ExecutionStateAskForBidAccepted:
+				return "waiting for the orchestrator to approve a node's bid"
+			}
+		}
+	}
+	if jobInfo.Job.State.StateType == models.JobStateTypeQueued {
+		return "waiting for a node with enough capacity"
+	}
+
+	return "waiting to be scheduled"
+}
+
+// Report a job that never reached a terminal state and ask the cluster to stop it
+func abandonJob(jobs jobsAPI, jobID string, cause error) {
+	if errors.Is(cause, context.DeadlineExceeded) {
+		slog.Error("Job did not reach terminal state within deadline", "jobID", jobID, "error", cause)
+	} else if errors.Is(cause, errPollBudgetExhausted) {
+		slog.Error("Job did not reach terminal state within poll budget", "jobID", jobID, "error", cause)
+	} else {
+		slog.Warn("Interrupted while waiting for job", "jobID", jobID)
+	}
+
+	// The polling context is already done, so give the stop request its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	slog.Info("Asking Bacalhau to stop job", "jobID", jobID)
+	_, err := jobs.Stop(ctx, &apimodels.StopJobRequest{
+		JobID:  jobID,
+		Reason: "client gave up waiting for job to finish",
+	})
+	if err != nil {
+		slog.Error("Failed to stop job", "jobID", jobID, "error", err)
+		return
+	}
+	slog.Info("Stop requested for job", "jobID", jobID)
+}
+
+// Get the ID of the first running execution, or empty to let the server choose
+func runningExecutionID(jobInfo *apimodels.GetJobResponse) string {
+	if jobInfo.Executions == nil {
+		return ""
+	}
+
+	for _, execution := range jobInfo.Executions.Items {
+		if execution.ComputeState.StateType == models.ExecutionStateRunning {
+			return execution.ID
+		}
+	}
+
+	return ""
+}
+
+// Log each execution whose state differs from the last poll, so partial
+// failures of multi-execution jobs can be diagnosed
+func logExecutionChanges(jobInfo *apimodels.GetJobResponse, seen map[string]models.ExecutionStateType) {
+	if jobInfo.Executions == nil {
+		return
+	}
+
+	for _, execution := range jobInfo.Executions.Items {
+		state := execution.ComputeState.StateType
+		if last, ok := seen[execution.ID]; ok && last == state {
+			continue
+		}
+		seen[execution.ID] = state
+
+		attrs := []any{
+			"jobID", execution.JobID,
+			"executionID", execution.ID,
+			"nodeID", execution.NodeID,
+			"state", state.String(),
+		}
+		if execution.ComputeState.Message != "" {
+			attrs = append(attrs, "message", execution.ComputeState.Message)
+		}
+		if state == models.ExecutionStateFailed {
+			slog.Warn("Execution failed", attrs...)
+		} else {
+			slog.Info("Execution state changed", attrs...)
+		}
+	}
+}
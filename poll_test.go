@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/bacerrors"
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+func TestWaitForJob(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []models.JobStateType
+		want   models.JobStateType
+	}{
+		{
+			name:   "running to completed",
+			states: []models.JobStateType{models.JobStateTypePending, models.JobStateTypeRunning, models.JobStateTypeRunning, models.JobStateTypeCompleted},
+			want:   models.JobStateTypeCompleted,
+		},
+		{
+			name:   "running to failed",
+			states: []models.JobStateType{models.JobStateTypeRunning, models.JobStateTypeFailed},
+			want:   models.JobStateTypeFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newFakeJobs(tt.states...)
+			opts := testOptions(t)
+
+			jobInfo, err := waitForJob(context.Background(), jobs, "job-1", opts, &jobTimeline{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := jobInfo.Job.State.StateType; got != tt.want {
+				t.Fatalf("got state %s, want %s", got, tt.want)
+			}
+			if got := jobs.getCount("job-1"); got != len(tt.states) {
+				t.Fatalf("got %d status checks, want %d", got, len(tt.states))
+			}
+		})
+	}
+}
+
+// Send the default logger's output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return &buf
+}
+
+func TestWaitForJobDeadline(t *testing.T) {
+	jobs := newFakeJobs(models.JobStateTypeRunning)
+	opts := testOptions(t)
+	logs := captureLogs(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := waitForJob(ctx, jobs, "job-1", opts, &jobTimeline{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	abandonJob(jobs, "job-1", ctx.Err())
+	if !strings.Contains(logs.String(), "Job did not reach terminal state within deadline") {
+		t.Fatalf("deadline wasn't reported, logs:\n%s", logs)
+	}
+	if !slices.Equal(jobs.stops, []string{"job-1"}) {
+		t.Fatalf("got stop requests for %v, want job-1", jobs.stops)
+	}
+}
+
+func TestRetryFailedJob(t *testing.T) {
+	failed := []models.JobStateType{models.JobStateTypeRunning, models.JobStateTypeFailed}
+	completed := []models.JobStateType{models.JobStateTypeRunning, models.JobStateTypeCompleted}
+
+	tests := []struct {
+		name      string
+		first     models.JobStateType
+		submitted [][]models.JobStateType
+		retries   string
+		wantJobID string
+		wantState models.JobStateType
+		wantPuts  int
+	}{
+		{
+			name:      "completed without retrying",
+			first:     models.JobStateTypeCompleted,
+			retries:   "2",
+			wantJobID: "job-1",
+			wantState: models.JobStateTypeCompleted,
+		},
+		{
+			name:      "failed without retries",
+			first:     models.JobStateTypeFailed,
+			retries:   "0",
+			wantJobID: "job-1",
+			wantState: models.JobStateTypeFailed,
+		},
+		{
+			name:      "resubmission completes",
+			first:     models.JobStateTypeFailed,
+			submitted: [][]models.JobStateType{completed},
+			retries:   "2",
+			wantJobID: "job-2",
+			wantState: models.JobStateTypeCompleted,
+			wantPuts:  1,
+		},
+		{
+			name:      "every attempt fails",
+			first:     models.JobStateTypeFailed,
+			submitted: [][]models.JobStateType{failed, failed},
+			retries:   "2",
+			wantJobID: "job-3",
+			wantState: models.JobStateTypeFailed,
+			wantPuts:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newFakeJobs(tt.first)
+			jobs.submitted = tt.submitted
+			opts := testOptions(t, "-retries-on-failure", tt.retries)
+			jobInfo, err := jobs.Get(context.Background(), &apimodels.GetJobRequest{JobID: "job-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			job := getJob(opts)
+			jobID, jobInfo, err := retryFailedJob(context.Background(), jobs, job, "job-1", jobInfo, opts, &jobTimeline{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jobID != tt.wantJobID {
+				t.Fatalf("got job %s, want %s", jobID, tt.wantJobID)
+			}
+			if got := jobInfo.Job.State.StateType; got != tt.wantState {
+				t.Fatalf("got state %s, want %s", got, tt.wantState)
+			}
+			if len(jobs.puts) != tt.wantPuts {
+				t.Fatalf("got %d resubmissions, want %d", len(jobs.puts), tt.wantPuts)
+			}
+			// Each attempt resubmits the same spec
+			for _, put := range jobs.puts {
+				if put == &job || put.Name != job.Name {
+					t.Fatalf("resubmitted %+v, want a copy of %+v", put, job)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitJobWarnings(t *testing.T) {
+	jobs := newFakeJobs()
+	jobs.warnings = []string{"field Deprecated is ignored", "no node matches the constraints"}
+	logs := captureLogs(t)
+	job := getJob(testOptions(t))
+
+	jobID, err := submitJob(context.Background(), jobs, &job, 0)
+	if err != nil {
+		t.Fatalf("warnings must not fail the submission: %v", err)
+	}
+	if jobID != "job-1" {
+		t.Fatalf("got job %s, want job-1", jobID)
+	}
+	for _, warning := range jobs.warnings {
+		if !strings.Contains(logs.String(), `msg="Orchestrator warning" jobID=job-1 warning="`+warning+`"`) {
+			t.Fatalf("warning %q wasn't logged, logs:\n%s", warning, logs)
+		}
+	}
+}
+
+func TestGetJobStatusRetries(t *testing.T) {
+	unavailable := bacerrors.New("orchestrator unavailable").WithCode(bacerrors.ServiceUnavailable)
+	notFound := bacerrors.New("job not found").WithCode(bacerrors.NotFoundError)
+
+	tests := []struct {
+		name    string
+		getErrs []error
+		retries string
+		wantErr error
+		// Status checks that reached the job
+		wantGets int
+	}{
+		{name: "recovers", getErrs: []error{unavailable, unavailable, unavailable}, retries: "5", wantGets: 1},
+		{name: "gives up", getErrs: []error{unavailable, unavailable, unavailable}, retries: "2", wantErr: unavailable},
+		{name: "not retryable", getErrs: []error{notFound}, retries: "5", wantErr: notFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newFakeJobs(models.JobStateTypeCompleted)
+			jobs.getErrs = tt.getErrs
+			opts := testOptions(t, "-poll-retries", tt.retries)
+
+			jobInfo, err := getJobStatus(context.Background(), jobs, "job-1", opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got := jobInfo.Job.State.StateType; got != models.JobStateTypeCompleted {
+				t.Fatalf("got state %s, want Completed", got)
+			}
+			if got := jobs.getCount("job-1"); got != tt.wantGets {
+				t.Fatalf("got %d status checks through, want %d", got, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestWaitForJobResetsStatusRetries(t *testing.T) {
+	unavailable := bacerrors.New("orchestrator unavailable").WithCode(bacerrors.ServiceUnavailable)
+	jobs := newFakeJobs(models.JobStateTypeRunning, models.JobStateTypeCompleted)
+	// Four failures in all, but never more than two in a row
+	jobs.getErrs = []error{unavailable, unavailable, nil, unavailable, unavailable}
+	opts := testOptions(t, "-poll-retries", "2")
+
+	jobInfo, err := waitForJob(context.Background(), jobs, "job-1", opts, &jobTimeline{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobInfo.Job.State.StateType; got != models.JobStateTypeCompleted {
+		t.Fatalf("got state %s, want Completed", got)
+	}
+}
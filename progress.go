@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// How often download progress is reported
+const progressInterval = 1 * time.Second
+
+// Reader that periodically reports how many bytes have passed through it
+type progressReader struct {
+	r          io.Reader
+	w          io.Writer
+	read       int64
+	total      int64
+	lastReport time.Time
+}
+
+// Wrap r to report progress to w, starting from offset bytes out of a total
+// that is -1 when unknown
+func newProgressReader(r io.Reader, w io.Writer, offset, total int64) *progressReader {
+	return &progressReader{
+		r:          r,
+		w:          w,
+		read:       offset,
+		total:      total,
+		lastReport: time.Now(),
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	if time.Since(p.lastReport) >= progressInterval {
+		p.report()
+	}
+
+	return n, err
+}
+
+func (p *progressReader) report() {
+	p.lastReport = time.Now()
+
+	if p.total > 0 {
+		fmt.Fprintf(p.w, "\rDownloaded %s of %s (%d%%)", formatBytes(p.read), formatBytes(p.total), p.read*100/p.total)
+	} else {
+		fmt.Fprintf(p.w, "\rDownloaded %s", formatBytes(p.read))
+	}
+}
+
+// Print the final count and end the progress line
+func (p *progressReader) finish() {
+	p.report()
+	fmt.Fprintln(p.w)
+}
+
+// Format a byte count with a binary unit, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Report whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
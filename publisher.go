@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Key used for -publisher s3 when -publisher-key isn't given. Bacalhau fills
+// in the placeholders and appends the archive extension.
+const defaultPublisherKey = "bacalhau-results/{jobID}/{executionID}"
+
+// Build the task publisher for the selected -publisher
+func getPublisher(opts *options) *models.SpecConfig {
+	switch opts.publisher {
+	case "s3":
+		params := map[string]any{
+			"Bucket": opts.publisherBucket,
+			"Key":    opts.publisherKey,
+		}
+		if opts.publisherRegion != "" {
+			params["Region"] = opts.publisherRegion
+		}
+		return &models.SpecConfig{
+			Type:   "s3",
+			Params: params,
+		}
+	case "ipfs":
+		return &models.SpecConfig{
+			Type: "ipfs",
+		}
+	}
+
+	return &models.SpecConfig{
+		Type: "local",
+	}
+}
+
+// Check the publisher flags are consistent with each other
+func validatePublisher(opts *options) error {
+	switch opts.publisher {
+	case "local", "ipfs":
+		if opts.publisherBucket != "" || opts.publisherKey != "" || opts.publisherRegion != "" {
+			return fmt.Errorf("publisher-bucket, publisher-key and publisher-region only apply to -publisher s3")
+		}
+		return nil
+	case "s3":
+		if opts.publisherBucket == "" {
+			return fmt.Errorf("publisher-bucket is required with -publisher s3")
+		}
+		if opts.publisherKey == "" {
+			opts.publisherKey = defaultPublisherKey
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid publisher %q: expected local, s3 or ipfs", opts.publisher)
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// What retrieving a job's outputs did, logged once it's finished
+type retrievalResult struct {
+	// Output directory, or the archive with -no-extract
+	path            string
+	files           []outputFile
+	bytesDownloaded int64
+	filesExtracted  int
+	bytesExtracted  int64
+	treeHash        string
+	duration        time.Duration
+}
+
+// Retrieve a job's outputs, timing the whole retrieval including the wait for
+// results to be published
+func retrieveOutputs(ctx context.Context, jobs jobsAPI, job *models.Job, opts *options) (*retrievalResult, error) {
+	start := time.Now()
+	result, err := retrieveJobOutputs(ctx, jobs, job, opts)
+	if err != nil {
+		opts.metrics.retrievalFailed()
+		return result, err
+	}
+	result.duration = time.Since(start)
+
+	return result, nil
+}
+
+// Retrieval that failed after it started writing into the output path, which
+// may hold partially extracted files
+type partialOutputError struct {
+	path string
+	err  error
+}
+
+func (e partialOutputError) Error() string {
+	return e.err.Error()
+}
+
+func (e partialOutputError) Unwrap() error {
+	return e.err
+}
+
+// Report the output path alongside a failure, so what was written can be
+// inspected or removed
+func partialOutputs(outputPath string, err error) (*retrievalResult, error) {
+	return &retrievalResult{path: outputPath}, partialOutputError{path: outputPath, err: err}
+}
+
+// Remove partial outputs with -cleanup-on-error, or point at them otherwise
+func cleanupPartialOutputs(jobID, outputPath string, remove bool) {
+	if _, err := os.Stat(outputPath); err != nil {
+		return
+	}
+	if !remove {
+		slog.Warn("Partial outputs left in place, pass -cleanup-on-error to remove them", "jobID", jobID, "path", outputPath)
+		return
+	}
+
+	if err := os.RemoveAll(outputPath); err != nil {
+		slog.Warn("Failed to remove partial outputs", "jobID", jobID, "path", outputPath, "error", err)
+		return
+	}
+	slog.Info("Removed partial outputs", "jobID", jobID, "path", outputPath)
+}
+
+func retrieveJobOutputs(ctx context.Context, jobs jobsAPI, job *models.Job, opts *options) (*retrievalResult, error) {
+	jobID := job.ID
+	results, err := waitForResults(ctx, jobs, jobID, opts.resultsWait)
+	if err != nil {
+		return nil, err
+	}
+	// IPFS results are only addressed by CID, so there's nothing to download over HTTP
+	if job.Task() != nil && job.Task().Publisher.IsType("ipfs") {
+		return nil, fmt.Errorf("results were published to IPFS as %s, fetch them with an IPFS client",
+			strings.Join(describeResults(results.Items), ", "))
+	}
+
+	if opts.allResults {
+		return retrieveAllOutputs(ctx, job, results.Items, opts)
+	}
+
+	if len(results.Items) > 1 && opts.resultName == "" {
+		slog.Warn("Job has multiple results, retrieving the first one. Use -result-name to pick another, or -all-results",
+			"jobID", jobID, "results", len(results.Items))
+	}
+	resultsURL, err := selectResultURL(results.Items, opts.resultName)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.stdout {
+		if err := streamResultFile(ctx, resultsURL, opts.resultFile, os.Stdout, opts); err != nil {
+			return nil, fmt.Errorf("error streaming result file: %s", err.Error())
+		}
+		return &retrievalResult{}, nil
+	}
+
+	resultsDir, outputPath, err := prepareResultsDir(job, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get data from Bacalhau and extract it
+	download := resultDownload{
+		url:         resultsURL,
+		archivePath: filepath.Join(resultsDir, jobID+archiveExtension(resultsURL, opts.extract.format)),
+		dest:        outputPath,
+	}
+	downloaded, err := fetchResult(ctx, jobID, download, opts)
+	if err != nil {
+		return partialOutputs(outputPath, err)
+	}
+	if opts.noExtract {
+		return &retrievalResult{path: download.archivePath, bytesDownloaded: downloaded}, nil
+	}
+	if !opts.extract.filtered() {
+		checkResultPaths(job, outputPath)
+	}
+
+	result, err := finishOutputs(job, outputPath, opts, redactURL(resultsURL))
+	if err != nil {
+		return partialOutputs(outputPath, err)
+	}
+	result.bytesDownloaded = downloaded
+
+	return result, nil
+}
+
+// Download every result of the job concurrently, extracting each into its own
+// subdirectory of the job's output path
+func retrieveAllOutputs(ctx context.Context, job *models.Job, items []*models.SpecConfig, opts *options) (*retrievalResult, error) {
+	jobID := job.ID
+	named, err := selectResultURLs(items)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsDir, outputPath, err := prepareResultsDir(job, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	downloads := make([]resultDownload, 0, len(named))
+	redacted := make([]string, 0, len(named))
+	for _, result := range named {
+		downloads = append(downloads, resultDownload{
+			name:        result.name,
+			url:         result.url,
+			archivePath: filepath.Join(resultsDir, jobID+"-"+result.name+archiveExtension(result.url, opts.extract.format)),
+			dest:        filepath.Join(outputPath, result.name),
+		})
+		redacted = append(redacted, redactURL(result.url))
+	}
+	slog.Info("Retrieving all results", "jobID", jobID, "results", len(downloads), "concurrency", opts.downloadConcurrency)
+
+	downloaded, err := fetchResults(ctx, jobID, downloads, opts)
+	if err != nil {
+		return partialOutputs(outputPath, err)
+	}
+	for _, download := range downloads {
+		if !opts.extract.filtered() {
+			checkResultPaths(job, download.dest)
+		}
+	}
+
+	result, err := finishOutputs(job, outputPath, opts, redacted...)
+	if err != nil {
+		return partialOutputs(outputPath, err)
+	}
+	result.bytesDownloaded = downloaded
+
+	return result, nil
+}
+
+// Resolve the results directory and make sure the job's output path is free
+func prepareResultsDir(job *models.Job, opts *options) (string, string, error) {
+	outputPath, err := jobOutputPath(job, opts)
+	if err != nil {
+		return "", "", err
+	}
+	// Archives are downloaded next to the directory they're extracted into
+	resultsDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(resultsDir, 0755); err != nil {
+		return "", "", fmt.Errorf("error creating output directory: %s", err.Error())
+	}
+
+	// Nothing is extracted with -no-extract, so only the archive is written
+	if opts.noExtract {
+		return resultsDir, outputPath, nil
+	}
+	if err := prepareOutputPath(outputPath, opts.overwrite); err != nil {
+		return "", "", err
+	}
+
+	return resultsDir, outputPath, nil
+}
+
+// Values available to -output-template
+type outputPathData struct {
+	JobID string
+	Name  string
+	// Day the job was created, as YYYY-MM-DD
+	Date string
+}
+
+// Parse an -output-template, rendering it once with sample values so unknown
+// fields are reported at startup rather than after the job has run
+func parseOutputTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template %q: %s", text, err.Error())
+	}
+	if err := tmpl.Execute(io.Discard, outputPathData{}); err != nil {
+		return nil, fmt.Errorf("invalid output template %q: %s", text, err.Error())
+	}
+
+	return tmpl, nil
+}
+
+// Resolve where the job's outputs go: <output-dir>/<jobID>, or the rendered
+// -output-template
+func jobOutputPath(job *models.Job, opts *options) (string, error) {
+	outputPath := filepath.Join(opts.outputDir, job.ID)
+	if opts.outputTemplate != nil {
+		created := time.Now()
+		if job.CreateTime != 0 {
+			created = time.Unix(0, job.CreateTime)
+		}
+		var rendered strings.Builder
+		err := opts.outputTemplate.Execute(&rendered, outputPathData{
+			JobID: job.ID,
+			Name:  job.Name,
+			Date:  created.Format(time.DateOnly),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error rendering output template: %s", err.Error())
+		}
+		if strings.TrimSpace(rendered.String()) == "" {
+			return "", fmt.Errorf("output template rendered an empty path for job %s", job.ID)
+		}
+		outputPath = rendered.String()
+	}
+
+	outputPath, err := filepath.Abs(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("error resolving output directory: %s", err.Error())
+	}
+
+	return outputPath, nil
+}
+
+// List what landed on disk and record the run's manifest next to it
+func finishOutputs(job *models.Job, outputPath string, opts *options, resultURLs ...string) (*retrievalResult, error) {
+	// Confirm what landed on disk before the manifest is added
+	files, err := listFiles(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	logOutputFiles(job.ID, files, opts.listFiles)
+	treeHash, err := hashOutputTree(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeManifest(outputPath, newRunManifest(job, resultURLs...), opts.overwrite); err != nil {
+		return nil, err
+	}
+
+	result := &retrievalResult{path: outputPath, files: files, filesExtracted: len(files), treeHash: treeHash}
+	for _, file := range files {
+		result.bytesExtracted += file.Size
+	}
+
+	return result, nil
+}
+
+// How often to ask for results while waiting for them to be published
+const resultsPollInterval = 1 * time.Second
+
+// List the job's results, asking again for up to wait while none have been
+// published yet, since a job can complete before its publisher has finished
+func waitForResults(ctx context.Context, jobs jobsAPI, jobID string, wait time.Duration) (*apimodels.ListJobResultsResponse, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		results, err := jobs.Results(ctx, &apimodels.ListJobResultsRequest{
+			JobID: jobID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving results: %s", err.Error())
+		}
+		if len(results.Items) > 0 {
+			return results, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			if wait == 0 {
+				return nil, fmt.Errorf("job %s has no published results", jobID)
+			}
+			return nil, fmt.Errorf("job %s has no published results after waiting %s", jobID, wait)
+		}
+		slog.Debug("Results not published yet, retrying", "jobID", jobID)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(resultsPollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+// Keep the extension of plain tar and zip results so a kept archive is named
+// for what it holds, defaulting to .tar.gz. A format given by -archive-format
+// decides instead.
+func archiveExtension(resultURL, format string) string {
+	if format != "" {
+		return "." + format
+	}
+
+	name := strings.ToLower(resultFileName(resultURL))
+	for _, ext := range []string{".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+
+	return ".tar.gz"
+}
+
+// Each named result path is published as a subdirectory of the archive, so
+// warn about any that didn't make it into the extracted outputs
+func checkResultPaths(job *models.Job, outputPath string) {
+	task := job.Task()
+	if task == nil {
+		return
+	}
+
+	for _, resultPath := range task.ResultPaths {
+		dir := filepath.Join(outputPath, resultPath.Name)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			slog.Warn("Result path missing from outputs", "jobID", job.ID, "name", resultPath.Name, "path", resultPath.Path)
+		}
+	}
+}
+
+// Make sure a previous run's outputs aren't mixed into this one, removing them
+// only when overwriting is allowed
+func prepareOutputPath(outputPath string, overwrite bool) error {
+	_, err := os.Stat(outputPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking output path: %s", err.Error())
+	}
+
+	if !overwrite {
+		return fmt.Errorf("output path %s already exists, pass -overwrite to replace it", outputPath)
+	}
+	if err := os.RemoveAll(outputPath); err != nil {
+		return fmt.Errorf("error removing existing output path: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Pick the URL of the result matching name, or of the first result that has
+// one. Results are matched on their archive file name, with or without the
+// archive extension, which for the local publisher is the execution ID.
+func selectResultURL(items []*models.SpecConfig, name string) (string, error) {
+	for _, item := range items {
+		resultURL, fileName, err := resultLocation(item)
+		if err != nil {
+			return "", err
+		}
+		if resultURL == "" {
+			continue
+		}
+
+		if name == "" || fileName == name || trimArchiveExtension(fileName) == name {
+			return resultURL, nil
+		}
+	}
+
+	if name != "" {
+		return "", fmt.Errorf("no result named %q among %d results", name, len(items))
+	}
+	return "", fmt.Errorf("none of the %d results has a downloadable URL, they were published to %s",
+		len(items), strings.Join(describeResults(items), ", "))
+}
+
+// A downloadable result and the name its outputs are extracted under
+type namedResult struct {
+	name string
+	url  string
+}
+
+// Get every result that has a URL, named by its archive file name without the
+// extension. Repeated names get a numeric suffix so their outputs don't collide.
+func selectResultURLs(items []*models.SpecConfig) ([]namedResult, error) {
+	var named []namedResult
+	seen := map[string]int{}
+	for _, item := range items {
+		resultURL, fileName, err := resultLocation(item)
+		if err != nil {
+			return nil, err
+		}
+		if resultURL == "" {
+			continue
+		}
+
+		name := trimArchiveExtension(fileName)
+		seen[name]++
+		if seen[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, seen[name])
+		}
+		named = append(named, namedResult{name: name, url: resultURL})
+	}
+
+	if len(named) == 0 {
+		return nil, fmt.Errorf("none of the %d results has a downloadable URL, they were published to %s",
+			len(items), strings.Join(describeResults(items), ", "))
+	}
+
+	return named, nil
+}
+
+// Get the download URL and file name of a result. The local publisher returns
+// a URL, while S3 results carry a pre-signed URL when the orchestrator has
+// credentials to sign one. Other results have no URL.
+func resultLocation(item *models.SpecConfig) (string, string, error) {
+	param := "URL"
+	if item.IsType("s3PreSigned") {
+		param = "PreSignedURL"
+	}
+
+	raw, ok := item.Params[param]
+	if !ok {
+		return "", "", nil
+	}
+	resultURL, ok := raw.(string)
+	if !ok || resultURL == "" {
+		return "", "", fmt.Errorf("result of type %s has an invalid %s param: %v", item.Type, param, raw)
+	}
+
+	if key, ok := item.Params["Key"].(string); ok && key != "" {
+		return resultURL, path.Base(key), nil
+	}
+	return resultURL, resultFileName(resultURL), nil
+}
+
+// Get a short description of where each result was published
+func describeResults(items []*models.SpecConfig) []string {
+	descriptions := make([]string, 0, len(items))
+	for _, item := range items {
+		switch {
+		case item.IsType("ipfs"):
+			descriptions = append(descriptions, fmt.Sprintf("ipfs://%v", item.Params["CID"]))
+		case item.IsType("s3"), item.IsType("s3PreSigned"):
+			descriptions = append(descriptions, fmt.Sprintf("s3://%v/%v", item.Params["Bucket"], item.Params["Key"]))
+		default:
+			descriptions = append(descriptions, item.Type)
+		}
+	}
+
+	return descriptions
+}
+
+// Get the file name from a result URL, ignoring any query string
+func resultFileName(resultURL string) string {
+	u, err := url.Parse(resultURL)
+	if err != nil {
+		return path.Base(resultURL)
+	}
+
+	return path.Base(u.Path)
+}
+
+// Drop the query string from a URL so pre-signed credentials aren't recorded
+func redactURL(resultURL string) string {
+	u, err := url.Parse(resultURL)
+	if err != nil {
+		return resultURL
+	}
+	u.RawQuery = ""
+
+	return u.String()
+}
+
+// Strip a known archive extension from a result file name
+func trimArchiveExtension(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+
+	return name
+}
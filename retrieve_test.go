@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+func TestRetrieveOutputs(t *testing.T) {
+	jobs := newFakeJobs(models.JobStateTypeCompleted)
+	jobs.results = []*models.SpecConfig{serveResult(t, "exec-1.tar.gz", gzipBytes(t, tarArchive(t,
+		tarEntry{name: "outputs/output.txt", body: "hello"},
+	)))}
+	opts := testOptions(t)
+	job := getJob(opts)
+	job.ID = "job-1"
+
+	result, err := retrieveOutputs(context.Background(), jobs, &job, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(opts.outputDir, "job-1")
+	if result.path != want {
+		t.Fatalf("got path %s, want %s", result.path, want)
+	}
+	data, err := os.ReadFile(filepath.Join(want, "outputs", "output.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("got %q, want %q", data, "hello")
+	}
+	if result.filesExtracted != 1 {
+		t.Fatalf("got %d files extracted, want 1", result.filesExtracted)
+	}
+	if _, err := os.Stat(filepath.Join(opts.outputDir, "job-1.tar.gz")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("archive wasn't removed after extracting: %v", err)
+	}
+}
+
+func TestRetrieveOutputsCorruptArchive(t *testing.T) {
+	// Cut off part way through the second file, after the first is complete
+	archive := gzipBytes(t, tarArchive(t,
+		tarEntry{name: "outputs/first.txt", body: "complete"},
+		tarEntry{name: "outputs/second.txt", body: strings.Repeat("truncated ", 10000)},
+	))
+	archive = archive[:len(archive)-40]
+	jobs := newFakeJobs(models.JobStateTypeCompleted)
+	jobs.results = []*models.SpecConfig{serveResult(t, "exec-1.tar.gz", archive)}
+	opts := testOptions(t)
+	job := getJob(opts)
+	job.ID = "job-1"
+
+	result, err := retrieveOutputs(context.Background(), jobs, &job, opts)
+	var partial partialOutputError
+	if !errors.As(err, &partial) || !strings.Contains(err.Error(), "error extracting results archive") {
+		t.Fatalf("expected a partial output error from extracting, got %v", err)
+	}
+
+	want := filepath.Join(opts.outputDir, "job-1")
+	if partial.path != want {
+		t.Fatalf("got error path %s, want %s", partial.path, want)
+	}
+	if result == nil || result.path != want {
+		t.Fatalf("got result %+v, want path %s", result, want)
+	}
+	// What was extracted before the archive broke off is left to inspect
+	if _, err := os.Stat(filepath.Join(want, "outputs", "first.txt")); err != nil {
+		t.Fatalf("partial outputs weren't kept: %v", err)
+	}
+}
+
+func TestWaitForResults(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []*models.SpecConfig
+		resultsErr error
+		want       string
+	}{
+		{name: "API error", resultsErr: errors.New("connection refused"), want: "error retrieving results: connection refused"},
+		{name: "no results", want: "job job-1 has no published results"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newFakeJobs(models.JobStateTypeCompleted)
+			jobs.results, jobs.resultsErr = tt.results, tt.resultsErr
+
+			_, err := waitForResults(context.Background(), jobs, "job-1", 0)
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+
+			opts := testOptions(t, "-results-wait", "0s")
+			job := getJob(opts)
+			job.ID = "job-1"
+			if _, err := retrieveOutputs(context.Background(), jobs, &job, opts); err == nil || err.Error() != tt.want {
+				t.Fatalf("retrieveOutputs: got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectResultURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []*models.SpecConfig
+		want  string
+	}{
+		{name: "no results", want: "none of the 0 results has a downloadable URL, they were published to "},
+		{
+			name:  "no URLs",
+			items: []*models.SpecConfig{{Type: "ipfs", Params: map[string]any{"CID": "bafy"}}},
+			want:  "none of the 1 results has a downloadable URL, they were published to ipfs://bafy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := selectResultURL(tt.items, ""); err == nil || err.Error() != tt.want {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Print a JSON Schema for -job-file specs. It's generated from models.Job the
+// same way encoding/json reads it, so it can't drift from what's accepted.
+func runSchema(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s schema\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		slog.Error("Failed to parse flags", "error", err)
+		return 1
+	}
+	if fs.NArg() > 0 {
+		slog.Error("Failed to parse flags", "error", fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " ")))
+		return 1
+	}
+
+	jsonData, err := json.MarshalIndent(jobFileSchema(), "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode schema", "error", err)
+		return 1
+	}
+	fmt.Println(string(jsonData))
+
+	return 0
+}
+
+// Build the schema of a job file: a models.Job with at least one task, as
+// loadJobFile requires
+func jobFileSchema() map[string]any {
+	g := &schemaGenerator{defs: map[string]any{}}
+	// Job's own UnmarshalJSON only defaults Count, decoding its fields as usual
+	root := g.structRef(reflect.TypeOf(models.Job{}))
+	ref := root["$ref"].(string)
+	name := strings.TrimPrefix(ref, "#/$defs/")
+	job := g.defs[name].(map[string]any)
+	job["required"] = []string{"Tasks"}
+	job["properties"].(map[string]any)["Tasks"].(map[string]any)["minItems"] = 1
+
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Job file",
+		"description": "Job spec accepted by -job-file, as JSON or the equivalent YAML",
+		"$ref":        ref,
+		"$defs":       g.defs,
+	}
+}
+
+// Collects a definition per named struct type, so nested and recursive types
+// are described once and referenced from everywhere they're used
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Types that decode themselves accept whatever they say they do
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.PointerTo(t).Implements(jsonUnmarshalerType):
+		return map[string]any{"description": fmt.Sprintf("%s, in its own JSON format", t.Name())}
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json reads []byte as base64
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	}
+
+	// Interfaces and anything else take any value
+	return map[string]any{}
+}
+
+// Define a struct type, unless it already is, and reference it
+func (g *schemaGenerator) structRef(t reflect.Type) map[string]any {
+	name := schemaDefName(t)
+	if name == "" {
+		return g.structSchema(t)
+	}
+	ref := map[string]any{"$ref": "#/$defs/" + name}
+	if _, ok := g.defs[name]; ok {
+		return ref
+	}
+
+	// Claim the name first so recursive fields reference it
+	g.defs[name] = map[string]any{}
+	g.defs[name] = g.structSchema(t)
+
+	return ref
+}
+
+// Name a type's definition. Instances of generic types such as
+// State[models.JobStateType] become StateOfJobStateType.
+func schemaDefName(t reflect.Type) string {
+	name, args, generic := strings.Cut(t.Name(), "[")
+	if !generic {
+		return name
+	}
+
+	var params []string
+	for _, arg := range strings.Split(strings.TrimSuffix(args, "]"), ",") {
+		params = append(params, arg[strings.LastIndex(arg, ".")+1:])
+	}
+
+	return name + "Of" + strings.Join(params, "And")
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	g.addFields(t, properties)
+
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// Add the fields encoding/json decodes for t: exported fields under their
+// json tag names, with untagged embedded structs flattened into their parent
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]any) {
+	var embedded []reflect.Type
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			embedded = append(embedded, fieldType)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		// Fields of an outer struct win over embedded ones
+		if _, ok := properties[name]; !ok {
+			properties[name] = g.schemaFor(field.Type)
+		}
+	}
+
+	for _, embeddedType := range embedded {
+		g.addFields(embeddedType, properties)
+	}
+}
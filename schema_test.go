@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJobFileSchema(t *testing.T) {
+	jsonData, err := json.MarshalIndent(jobFileSchema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(jsonData, &schema); err != nil {
+		t.Fatalf("schema isn't valid JSON: %s", err)
+	}
+
+	defs, _ := schema["$defs"].(map[string]any)
+	// Every reference has to point at a definition
+	var checkRefs func(value any)
+	checkRefs = func(value any) {
+		switch value := value.(type) {
+		case map[string]any:
+			if ref, ok := value["$ref"].(string); ok {
+				if _, ok := defs[strings.TrimPrefix(ref, "#/$defs/")]; !ok {
+					t.Fatalf("reference to undefined %q", ref)
+				}
+			}
+			for _, v := range value {
+				checkRefs(v)
+			}
+		case []any:
+			for _, v := range value {
+				checkRefs(v)
+			}
+		}
+	}
+	checkRefs(schema)
+
+	// Look up a definition's properties, following the reference to it
+	properties := func(ref any) map[string]any {
+		t.Helper()
+		name := strings.TrimPrefix(ref.(string), "#/$defs/")
+		def, ok := defs[name].(map[string]any)
+		if !ok {
+			t.Fatalf("missing definition %q", name)
+		}
+		props, _ := def["properties"].(map[string]any)
+		return props
+	}
+
+	job := properties(schema["$ref"])
+	for _, field := range []string{"Name", "Namespace", "Type", "Count", "Labels", "Constraints", "Tasks"} {
+		if _, ok := job[field]; !ok {
+			t.Errorf("job is missing field %s", field)
+		}
+	}
+	jobDef := defs[strings.TrimPrefix(schema["$ref"].(string), "#/$defs/")].(map[string]any)
+	if required, _ := jobDef["required"].([]any); !slices.Contains(required, any("Tasks")) {
+		t.Errorf("got required %v, want Tasks", jobDef["required"])
+	}
+
+	tasks := job["Tasks"].(map[string]any)
+	if tasks["minItems"] != float64(1) {
+		t.Errorf("got Tasks minItems %v, want 1", tasks["minItems"])
+	}
+	task := properties(tasks["items"].(map[string]any)["$ref"])
+	for _, field := range []string{"Name", "Engine", "Publisher", "Env", "InputSources", "ResultPaths", "Resources", "Timeouts"} {
+		if _, ok := task[field]; !ok {
+			t.Errorf("task is missing field %s", field)
+		}
+	}
+}
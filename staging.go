@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Turn -input-glob matches and -input-inline contents into local inputs. A
+// single glob match is mounted directly as target/name, while several are
+// copied into a temporary staging directory that is mounted at target. Inline
+// contents are written to a file in their own staging directory. The returned
+// function removes the staging directories.
+func stageInputs(opts *options) (func(), error) {
+	var stagingDirs []string
+	cleanup := func() {
+		for _, dir := range stagingDirs {
+			if err := os.RemoveAll(dir); err != nil {
+				slog.Warn("Failed to remove staged inputs", "path", dir, "error", err)
+			}
+		}
+	}
+
+	for _, input := range opts.globInputs {
+		if len(input.matches) == 1 {
+			opts.inputs = append(opts.inputs, localInput{
+				hostPath: input.matches[0],
+				target:   path.Join(input.target, filepath.Base(input.matches[0])),
+			})
+			continue
+		}
+
+		dir, err := os.MkdirTemp("", "bacalhau-inputs-*")
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("error creating staging directory: %s", err.Error())
+		}
+		stagingDirs = append(stagingDirs, dir)
+
+		for _, match := range input.matches {
+			if err := copyFile(match, filepath.Join(dir, filepath.Base(match))); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("error staging input %s: %s", match, err.Error())
+			}
+		}
+		slog.Debug("Staged input files", "pattern", input.pattern, "files", len(input.matches), "path", dir)
+
+		opts.inputs = append(opts.inputs, localInput{
+			hostPath: dir,
+			target:   input.target,
+		})
+	}
+
+	for _, input := range opts.inlineInputs {
+		dir, err := os.MkdirTemp("", "bacalhau-inputs-*")
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("error creating staging directory: %s", err.Error())
+		}
+		stagingDirs = append(stagingDirs, dir)
+
+		// Written verbatim, so multi-line content keeps its line breaks
+		hostPath := filepath.Join(dir, path.Base(input.target))
+		if err := os.WriteFile(hostPath, []byte(input.content), 0644); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("error staging inline input %s: %s", input.target, err.Error())
+		}
+
+		opts.inputs = append(opts.inputs, localInput{
+			hostPath: hostPath,
+			target:   input.target,
+		})
+	}
+
+	return cleanup, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Download the results archive and write the single entry matching name to w,
+// without writing anything to disk. The entry is streamed as it's read, and the
+// rest of the archive is then read to check no other entry matches too. The
+// download is retried like any other until the first byte is written to w. A
+// plain gzipped result holds one file, which is written if name matches it.
+func streamResultFile(ctx context.Context, resultsURL, name string, w io.Writer, opts *options) error {
+	eo := opts.extract
+	out := &countingWriter{w: w}
+	// A progress bar would be drawn in among the file's content
+	streamOpts := *opts
+	streamOpts.quiet = true
+	_, err := streamDownload(ctx, resultsURL, &streamOpts, func(archive *bufio.Reader, _ int) error {
+		format, err := streamFormat(archive, resultsURL, eo)
+		if err != nil {
+			return err
+		}
+
+		var tarStream io.Reader = archive
+		switch format {
+		case formatTarGz:
+			gzr, err := gzip.NewReader(archive)
+			if err != nil {
+				return err
+			}
+			defer gzr.Close()
+			br := bufio.NewReader(gzr)
+			block, err := br.Peek(512)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if !isTarHeader(block) {
+				return writeGzipFile(br, gzipFileName(gzr.Name, resultsURL), name, out, eo)
+			}
+			tarStream = br
+		case formatTar:
+		case formatZip:
+			return fmt.Errorf("zip results can't be streamed, download them without -stdout")
+		}
+
+		return writeTarEntry(tarStream, name, out, eo)
+	}, func() bool {
+		// Output already written can't be taken back
+		return out.n == 0
+	})
+
+	return err
+}
+
+// Writer that counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// Write the decompressed content of a plain gzipped result, named fileName,
+// if name matches it
+func writeGzipFile(r io.Reader, fileName, name string, w io.Writer, eo extractOptions) error {
+	if !matchesResultFile(fileName, name) {
+		return fmt.Errorf("result file %q not found, the result is a single gzipped file named %s", name, fileName)
+	}
+
+	return copyResultFile(w, r, fileName, eo.maxBytes)
+}
+
+// Report whether an archive entry is the result file named by name, either
+// exactly or as a trailing path such as output.txt for outputs/output.txt
+func matchesResultFile(entryName, name string) bool {
+	want := path.Clean(strings.TrimPrefix(name, "/"))
+	entryName = path.Clean(strings.TrimPrefix(entryName, "/"))
+
+	return entryName == want || strings.HasSuffix(entryName, "/"+want)
+}
+
+// Copy a result file to w, failing if it's larger than maxBytes
+func copyResultFile(w io.Writer, r io.Reader, name string, maxBytes int64) error {
+	written, err := io.Copy(w, io.LimitReader(r, maxBytes))
+	if err != nil {
+		return err
+	}
+	// Anything left past the limit is an overrun
+	if written == maxBytes {
+		var next [1]byte
+		if n, _ := io.ReadFull(r, next[:]); n > 0 {
+			return fmt.Errorf("result file %s is larger than %d bytes", name, maxBytes)
+		}
+	}
+
+	return nil
+}
+
+// Write the content of the one regular file in the tar stream matching name,
+// either exactly or as a trailing path such as output.txt for outputs/output.txt.
+// The first match has already been written when a second one is found.
+func writeTarEntry(r io.Reader, name string, w io.Writer, eo extractOptions) error {
+	var matches []string
+	entries := 0
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entries++
+		if entries > eo.maxEntries {
+			return fmt.Errorf("archive has more than %d entries", eo.maxEntries)
+		}
+
+		entryName := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if header.Typeflag != tar.TypeReg || !matchesResultFile(entryName, name) {
+			continue
+		}
+		matches = append(matches, entryName)
+		if len(matches) > 1 {
+			return fmt.Errorf("result file %q is ambiguous, it matches %s", name, strings.Join(matches, ", "))
+		}
+
+		if err := copyResultFile(w, tr, entryName, eo.maxBytes); err != nil {
+			return err
+		}
+	}
+
+	if len(matches) == 0 {
+		return fmt.Errorf("result file %q not found in results archive", name)
+	}
+
+	return nil
+}
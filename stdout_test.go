@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Writer that records how much of the archive had been read when it was first
+// written to
+type firstWriteRecorder struct {
+	bytes.Buffer
+	archive   *readTracker
+	readFirst int64
+}
+
+func (w *firstWriteRecorder) Write(p []byte) (int, error) {
+	if w.Len() == 0 {
+		w.readFirst = w.archive.n
+	}
+
+	return w.Buffer.Write(p)
+}
+
+func TestWriteTarEntry(t *testing.T) {
+	filler := strings.Repeat("x", 64<<10)
+	archive := tarArchive(t,
+		tarEntry{name: "outputs/output.txt", body: "hello"},
+		tarEntry{name: "outputs/filler.bin", body: filler},
+		tarEntry{name: "logs/run.log", body: "log"},
+	)
+
+	tests := []struct {
+		name    string
+		file    string
+		want    string
+		wantErr string
+	}{
+		{name: "full path", file: "outputs/output.txt", want: "hello"},
+		{name: "trailing path", file: "output.txt", want: "hello"},
+		{name: "not found", file: "missing.txt", wantErr: `result file "missing.txt" not found in results archive`},
+		{name: "too large", file: "filler.bin", wantErr: "result file outputs/filler.bin is larger than 1024 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eo := testExtractOptions()
+			eo.maxBytes = 1024
+			src := &readTracker{r: bytes.NewReader(archive)}
+			w := &firstWriteRecorder{archive: src}
+
+			err := writeTarEntry(src, tt.file, w, eo)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.String() != tt.want {
+				t.Fatalf("got %q, want %q", w.String(), tt.want)
+			}
+			// Streamed before the rest of the archive was read
+			if w.readFirst >= int64(len(archive))/2 {
+				t.Fatalf("entry was written after reading %d of %d bytes", w.readFirst, len(archive))
+			}
+		})
+	}
+}
+
+func TestWriteTarEntryAmbiguous(t *testing.T) {
+	archive := tarArchive(t,
+		tarEntry{name: "a/output.txt", body: "first"},
+		tarEntry{name: "b/output.txt", body: "second"},
+	)
+
+	err := writeTarEntry(bytes.NewReader(archive), "output.txt", io.Discard, testExtractOptions())
+	want := `result file "output.txt" is ambiguous, it matches a/output.txt, b/output.txt`
+	if err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+}
+
+func TestStreamResultFile(t *testing.T) {
+	tarGz := gzipBytes(t, tarArchive(t, tarEntry{name: "outputs/output.txt", body: "hello"}))
+	var plainGz bytes.Buffer
+	gw := gzip.NewWriter(&plainGz)
+	gw.Name = "output.txt"
+	if _, err := gw.Write([]byte("plain hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		// Responses to fail with before serving the archive
+		failures int
+		// Declare a longer body than is sent
+		truncate bool
+		file     string
+		want     string
+		wantErr  string
+	}{
+		{name: "tar.gz", archive: tarGz, file: "output.txt", want: "hello"},
+		{name: "plain gzip", archive: plainGz.Bytes(), file: "output.txt", want: "plain hello"},
+		{
+			name:    "plain gzip of another file",
+			archive: plainGz.Bytes(),
+			file:    "other.txt",
+			wantErr: `result file "other.txt" not found, the result is a single gzipped file named output.txt`,
+		},
+		{name: "retried server error", archive: tarGz, failures: 1, file: "output.txt", want: "hello"},
+		{
+			name:     "truncated",
+			archive:  tarGz,
+			truncate: true,
+			file:     "output.txt",
+			wantErr:  "error reading results: unexpected EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(requests.Add(1)) <= tt.failures {
+					http.Error(w, "try again", http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "application/gzip")
+				if tt.truncate {
+					w.Header().Set("Content-Length", fmt.Sprint(len(tt.archive)+100))
+				}
+				w.Write(tt.archive)
+			}))
+			defer server.Close()
+			// Output written by a cut-off attempt can't be retried
+			opts := testOptions(t, "-download-retries", "1")
+
+			var out bytes.Buffer
+			err := streamResultFile(context.Background(), server.URL+"/results.gz", tt.file, &out, opts)
+			if got := int(requests.Load()); got != tt.failures+1 {
+				t.Fatalf("got %d requests, want %d", got, tt.failures+1)
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestStdoutRejectsVerifySHA256(t *testing.T) {
+	_, err := parseFlags([]string{
+		"-output-dir", t.TempDir(), "-stdout", "-result-file", "output.txt",
+		"-verify-sha256", strings.Repeat("0", 64),
+	})
+	if err == nil || err.Error() != "stdout can't be combined with -verify-sha256" {
+		t.Fatalf("got error %v, want -verify-sha256 rejected", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// Stop a job that is still running, such as one submitted with -wait=false
+func runStop(args []string) int {
+	opts, jobID, reason, err := parseStopFlags(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("Failed to parse flags", "error", err)
+		return 1
+	}
+	slog.SetDefault(opts.logger)
+	stopReporting, err := startReporting(opts)
+	if err != nil {
+		slog.Error("Failed to start reporting", "error", err)
+		return 1
+	}
+	defer stopReporting()
+
+	ctx, _, cancel := newRunContext(opts)
+	defer cancel()
+
+	jobs := newJobsClient(opts)
+
+	// Stopping a finished job would only record a misleading reason
+	jobInfo, err := jobs.Get(ctx, &apimodels.GetJobRequest{
+		JobID: jobID,
+	})
+	if err != nil {
+		slog.Error("Failed to get job", "jobID", jobID, "error", err)
+		return 1
+	}
+	if state := jobInfo.Job.State.StateType; state.IsTerminal() {
+		slog.Error("Job has already finished", "jobID", jobID, "state", state.String())
+		return 1
+	}
+
+	resp, err := jobs.Stop(ctx, &apimodels.StopJobRequest{
+		JobID:  jobID,
+		Reason: reason,
+	})
+	if err != nil {
+		slog.Error("Failed to stop job", "jobID", jobID, "error", err)
+		return 1
+	}
+	slog.Info("Job stopped", "jobID", jobID, "evaluationID", resp.EvaluationID)
+
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Download resultsURL and extract it into dest as it arrives, without writing
+// the archive to disk, retrying connection errors and 5xx responses with
+// backoff. Returns the number of bytes downloaded by the successful attempt.
+func streamExtractResults(ctx context.Context, resultsURL, dest string, opts *options) (int64, error) {
+	eo := opts.extract
+	return streamDownload(ctx, resultsURL, opts, func(archive *bufio.Reader, attempt int) error {
+		// Files extracted by a failed attempt are extracted again
+		if attempt > 1 {
+			eo.overwrite = true
+		}
+
+		format, err := streamFormat(archive, resultsURL, eo)
+		if err != nil {
+			return err
+		}
+		switch format {
+		case formatTarGz:
+			err = extractTarGzStream(archive, dest, resultsURL, eo)
+		case formatTar:
+			err = extractTarStream(archive, dest, eo)
+		case formatZip:
+			return fmt.Errorf("zip results can't be extracted while streaming, download them without -stream-extract")
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting results archive: %s", err.Error())
+		}
+
+		return nil
+	}, nil)
+}
+
+// Download resultsURL and pass its body to read as it arrives, retrying
+// connection errors and 5xx responses with backoff while canRetry, if given,
+// allows it. read is given the attempt number, counting from 1. Returns the
+// number of bytes downloaded by the successful attempt.
+func streamDownload(ctx context.Context, resultsURL string, opts *options, read func(archive *bufio.Reader, attempt int) error, canRetry func() bool) (int64, error) {
+	backoff := downloadRetryBackoff
+	for attempt := 1; ; attempt++ {
+		downloaded, err := streamDownloadOnce(ctx, resultsURL, opts, func(archive *bufio.Reader) error {
+			return read(archive, attempt)
+		})
+		if err == nil {
+			return downloaded, nil
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		var retryable retryableError
+		if !errors.As(err, &retryable) || attempt > opts.downloadRetries || (canRetry != nil && !canRetry()) {
+			return 0, err
+		}
+
+		slog.Warn("Download failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Download resultsURL and pass its body to read in a single attempt
+func streamDownloadOnce(ctx context.Context, resultsURL string, opts *options, read func(archive *bufio.Reader) error) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resultsURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating GET request: %s", err.Error())
+	}
+	resp, err := opts.downloadClient.Do(req)
+	if err != nil {
+		return 0, retryableError{fmt.Errorf("error making GET request: %s", err.Error())}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return 0, retryableError{fmt.Errorf("bad status: %s", resp.Status)}
+	default:
+		return 0, fmt.Errorf("bad status: %s: %s", resp.Status, bodySnippet(resp.Body))
+	}
+
+	// Read errors come from the connection, and are worth retrying, while
+	// anything else read reports is a problem with the archive
+	body := &readTracker{r: resp.Body}
+	var src io.Reader = body
+	if !opts.quiet && isTerminal(os.Stderr) {
+		progress := newProgressReader(body, os.Stderr, 0, resp.ContentLength)
+		defer progress.finish()
+		src = progress
+	}
+	archive := bufio.NewReader(src)
+	if err := checkArchiveResponse(resp.Header.Get("Content-Type"), archive); err != nil {
+		return 0, err
+	}
+
+	err = read(archive)
+	if err == nil {
+		// Read to the end, so a truncated body is noticed even when the
+		// archive's end marker arrived intact
+		_, err = io.Copy(io.Discard, archive)
+	}
+	if err != nil {
+		if body.err != nil {
+			return 0, retryableError{fmt.Errorf("error reading results: %s", body.err.Error())}
+		}
+		return 0, err
+	}
+	if resp.ContentLength >= 0 && body.n != resp.ContentLength {
+		return 0, retryableError{fmt.Errorf("download incomplete: got %d of %d bytes", body.n, resp.ContentLength)}
+	}
+
+	return body.n, nil
+}
+
+// Work out the format of an archive being streamed, from its first bytes or
+// else its URL, unless -archive-format gave it
+func streamFormat(archive *bufio.Reader, resultsURL string, eo extractOptions) (string, error) {
+	if eo.format != "" {
+		return eo.format, nil
+	}
+	head, _ := archive.Peek(512)
+	if format := sniffArchiveFormat(head); format != "" {
+		return format, nil
+	}
+
+	return archiveFormatFromURL(resultsURL)
+}
+
+// Reader that counts the bytes read and remembers the first read error, other
+// than the end of the stream
+type readTracker struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (t *readTracker) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	if err != nil && err != io.EOF && t.err == nil {
+		t.err = err
+	}
+
+	return n, err
+}
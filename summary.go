@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+)
+
+// Final result of a run, printed to stdout with -output json
+type runSummary struct {
+	JobID      string       `json:"jobID"`
+	State      string       `json:"state"`
+	OutputPath string       `json:"outputPath,omitempty"`
+	Archive    string       `json:"archive,omitempty"`
+	Files      []outputFile `json:"files"`
+	TreeSHA256 string       `json:"treeSHA256,omitempty"`
+}
+
+// A file extracted from the results, relative to the output path
+type outputFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Build the summary for a job and the files extracted to outputPath, if any
+func newRunSummary(job *models.Job, outputPath string, files []outputFile) runSummary {
+	if files == nil {
+		files = []outputFile{}
+	}
+
+	return runSummary{
+		JobID:      job.ID,
+		State:      job.State.StateType.String(),
+		OutputPath: outputPath,
+		Files:      files,
+	}
+}
+
+func writeSummary(w io.Writer, summary runSummary) error {
+	jsonData, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding summary: %s", err.Error())
+	}
+
+	_, err = fmt.Fprintln(w, string(jsonData))
+	return err
+}
+
+// List the files under dir with their sizes, as slash-separated paths relative to it
+func listFiles(dir string) ([]outputFile, error) {
+	files := []outputFile{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, outputFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing output files: %s", err.Error())
+	}
+
+	return files, nil
+}
+
+// Log how much was extracted, warning when nothing was, and with -list-files
+// log every file
+func logOutputFiles(jobID string, files []outputFile, each bool) {
+	if len(files) == 0 {
+		slog.Warn("No files were extracted from the results", "jobID", jobID)
+		return
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.Size
+		if each {
+			slog.Info("Extracted file", "path", file.Path, "size", file.Size)
+		}
+	}
+	slog.Info("Extracted results", "jobID", jobID, "files", len(files), "bytes", total)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// A job state and when it was first observed
+type stateTransition struct {
+	state string
+	at    time.Time
+}
+
+// States a job went through, in the order the client observed them
+type jobTimeline struct {
+	transitions []stateTransition
+}
+
+// Record a state seen at the given time, ignoring repeats of the current state
+func (t *jobTimeline) record(state string, at time.Time) {
+	if n := len(t.transitions); n > 0 && t.transitions[n-1].state == state {
+		return
+	}
+	t.transitions = append(t.transitions, stateTransition{state: state, at: at})
+}
+
+// Total time from the first recorded state until end
+func (t *jobTimeline) elapsed(end time.Time) time.Duration {
+	if len(t.transitions) == 0 {
+		return 0
+	}
+
+	return end.Sub(t.transitions[0].at)
+}
+
+// Describe how long each state lasted, such as "submitted 1s → running 4s →
+// completed 200ms", with the last state lasting until end
+func (t *jobTimeline) format(end time.Time) string {
+	parts := make([]string, 0, len(t.transitions))
+	for i, transition := range t.transitions {
+		until := end
+		if i+1 < len(t.transitions) {
+			until = t.transitions[i+1].at
+		}
+		parts = append(parts, transition.state+" "+until.Sub(transition.at).Round(time.Millisecond).String())
+	}
+
+	return strings.Join(parts, " → ")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Build the TLS config for the API client from -tls-* flags, or nil to keep
+// the system defaults
+func newTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && !insecure {
+		return nil, nil
+	}
+	if caFile != "" && insecure {
+		return nil, fmt.Errorf("tls-ca-file and tls-insecure are mutually exclusive")
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if insecure {
+		config.InsecureSkipVerify = true
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file: %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in CA file %s", caFile)
+	}
+	config.RootCAs = pool
+
+	return config, nil
+}
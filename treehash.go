@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Compute one SHA-256 digest over every file and symlink under dir, so outputs
+// can be compared across runs and machines. Entries are walked in lexical
+// order and each contributes its slash-separated relative path followed by its
+// contents or link target, each prefixed with its length so no two trees hash
+// alike. Directories, modes and times are left out.
+func hashOutputTree(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			writeHashField(h, "l", filepath.ToSlash(rel))
+			writeHashField(h, "", target)
+		case entry.Type().IsRegular():
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			writeHashField(h, "f", filepath.ToSlash(rel))
+			binary.Write(h, binary.BigEndian, info.Size())
+			if err := hashTreeFile(h, path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error hashing outputs: %s", err.Error())
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write an entry's kind and a length-prefixed value
+func writeHashField(w io.Writer, kind, value string) {
+	io.WriteString(w, kind)
+	binary.Write(w, binary.BigEndian, int64(len(value)))
+	io.WriteString(w, value)
+}
+
+func hashTreeFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bacalhau-project/bacalhau/pkg/models"
+	"github.com/bacalhau-project/bacalhau/pkg/publicapi/apimodels"
+)
+
+// Keep listing the results of a job that publishes them as it runs, downloading
+// each one not seen before into its own subdirectory of the job's output path,
+// until the run is interrupted or times out
+func watchOutputs(ctx context.Context, jobs jobsAPI, job *models.Job, opts *options) int {
+	jobID := job.ID
+	resultsDir, outputPath, err := prepareResultsDir(job, opts)
+	if err != nil {
+		slog.Error("Unable to retrieve results", "jobID", jobID, "error", err)
+		return 1
+	}
+	slog.Info("Watching for new results", "jobID", jobID, "path", outputPath, "interval", opts.watchInterval)
+
+	// Pre-signed URLs change on every listing, so results are told apart by
+	// their URL without the query string
+	seen := map[string]bool{}
+	names := map[string]bool{}
+	var resultURLs []string
+	// Once written, the manifest is this run's own and is kept up to date
+	manifestWritten := false
+	for {
+		downloads := newWatchDownloads(ctx, jobs, jobID, resultsDir, outputPath, seen, names, opts)
+		fetched := 0
+		for _, download := range downloads {
+			if _, err := fetchResult(ctx, jobID, download, opts); err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("Failed to retrieve new result, retrying", "jobID", jobID, "name", download.name, "error", err)
+				}
+				// Start again from scratch on the next listing
+				os.RemoveAll(download.dest)
+				delete(names, download.name)
+				continue
+			}
+			fetched++
+			seen[redactURL(download.url)] = true
+			resultURLs = append(resultURLs, redactURL(download.url))
+			slog.Info("New results available", "jobID", jobID, "path", download.dest)
+			opts.events.emit(event{Event: "results_available", JobID: jobID, Path: download.dest})
+		}
+		if fetched > 0 {
+			err := writeManifest(outputPath, newRunManifest(job, resultURLs...), opts.overwrite || manifestWritten)
+			if err != nil {
+				slog.Warn("Failed to write manifest", "jobID", jobID, "error", err)
+			} else {
+				manifestWritten = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped watching for results", "jobID", jobID, "results", len(resultURLs))
+			return 0
+		case <-time.After(opts.watchInterval):
+		}
+	}
+}
+
+// List the job's results and plan a download for each one not seen before,
+// each named after its archive and numbered when that name is taken
+func newWatchDownloads(ctx context.Context, jobs jobsAPI, jobID, resultsDir, outputPath string, seen, names map[string]bool, opts *options) []resultDownload {
+	results, err := jobs.Results(ctx, &apimodels.ListJobResultsRequest{
+		JobID: jobID,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Failed to list results, retrying", "jobID", jobID, "error", err)
+		}
+		return nil
+	}
+	if len(results.Items) == 0 {
+		return nil
+	}
+	named, err := selectResultURLs(results.Items)
+	if err != nil {
+		slog.Warn("No downloadable results yet", "jobID", jobID, "error", err)
+		return nil
+	}
+
+	var downloads []resultDownload
+	for _, result := range named {
+		if seen[redactURL(result.url)] {
+			continue
+		}
+
+		name := result.name
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s-%d", result.name, i)
+		}
+		names[name] = true
+		downloads = append(downloads, resultDownload{
+			name:        name,
+			url:         result.url,
+			archivePath: filepath.Join(resultsDir, jobID+"-"+name+archiveExtension(result.url, opts.extract.format)),
+			dest:        filepath.Join(outputPath, name),
+		})
+	}
+
+	return downloads
+}
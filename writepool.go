@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Largest tar entry buffered in memory for the write pool. Larger entries are
+// written straight from the archive by the reading goroutine.
+const maxBufferedFileSize = 1 << 20
+
+// A file read from an archive, waiting to be written
+type bufferedFile struct {
+	target  string
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+// Bounded pool of goroutines writing buffered files. The queue holds at most
+// one file per worker, which caps the memory held by pending writes.
+type writePool struct {
+	files     chan bufferedFile
+	overwrite bool
+	workers   sync.WaitGroup
+	pending   sync.WaitGroup
+	closeOnce sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+func newWritePool(workers int, overwrite bool) *writePool {
+	p := &writePool{
+		files:     make(chan bufferedFile, workers),
+		overwrite: overwrite,
+	}
+
+	for range workers {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for f := range p.files {
+				p.write(f)
+				p.pending.Done()
+			}
+		}()
+	}
+
+	return p
+}
+
+func (p *writePool) write(f bufferedFile) {
+	// Skip the rest once anything has failed, the whole extraction will fail
+	if p.firstError() != nil {
+		return
+	}
+
+	out, err := createFile(f.target, f.mode, p.overwrite)
+	if err == nil {
+		if _, err = out.Write(f.data); err != nil {
+			out.abort()
+		} else {
+			err = out.commit(f.modTime)
+		}
+	}
+	if err != nil {
+		p.mu.Lock()
+		if p.err == nil {
+			p.err = err
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *writePool) firstError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Queue a file for writing, returning any error a worker has already hit
+func (p *writePool) submit(f bufferedFile) error {
+	if err := p.firstError(); err != nil {
+		return err
+	}
+
+	p.pending.Add(1)
+	p.files <- f
+
+	return nil
+}
+
+// Wait for every queued file to be written
+func (p *writePool) flush() error {
+	p.pending.Wait()
+
+	return p.firstError()
+}
+
+// Write what's queued and stop the workers. Safe to call more than once.
+func (p *writePool) close() error {
+	p.closeOnce.Do(func() {
+		close(p.files)
+		p.workers.Wait()
+	})
+
+	return p.firstError()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Compare writing thousands of small files in archive order with spreading
+// them across the write pool, as -extract-workers does
+func BenchmarkExtractSmallFiles(b *testing.B) {
+	entries := make([]tarEntry, 0, 5000)
+	body := strings.Repeat("x", 2<<10)
+	for i := range cap(entries) {
+		entries = append(entries, tarEntry{name: fmt.Sprintf("outputs/%02d/file-%04d.txt", i%50, i), body: body})
+	}
+	archive := tarArchive(b, entries...)
+	root := b.TempDir()
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			eo := extractOptions{maxBytes: 1 << 30, maxEntries: len(entries), workers: workers}
+			b.SetBytes(int64(len(entries) * len(body)))
+			b.ResetTimer()
+			for range b.N {
+				dst, err := os.MkdirTemp(root, "extract")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := extractTarStream(bytes.NewReader(archive), dst, eo); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(dst)
+				b.StartTimer()
+			}
+		})
+	}
+}